@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"math"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	screp "github.com/icza/screp/rep"
@@ -16,6 +20,9 @@ const (
 	chartBucketSeconds = 30
 	chartBucketCount   = chartWindowSeconds / chartBucketSeconds
 
+	// maxSupplyHalf is Brood War's 200 supply cap in half-supply units.
+	maxSupplyHalf = 400
+
 	supplyGreatThreshold = 15.0
 	supplySolidThreshold = 45.0
 	workerGreatThreshold = 45.0
@@ -68,19 +75,23 @@ type raceConfig struct {
 	startingSupplyHalf int
 	startingWorkers    int
 	workerUnitID       uint16
-	workerTrainSeconds int
+	workerTrainFrames  repcore.Frame
 	baseProducerCount  int
+	usesLarva          bool
 }
 
 type commandEvent struct {
-	second  int
+	frame   repcore.Frame
 	command repcmd.Cmd
 }
 
 type scheduledEvent struct {
-	second int
-	kind   string
-	unitID uint16
+	frame      repcore.Frame
+	kind       string
+	unitID     uint16
+	producer   *productionBuilding
+	order      int
+	supplyHalf int
 }
 
 var raceConfigs = map[byte]raceConfig{
@@ -88,22 +99,23 @@ var raceConfigs = map[byte]raceConfig{
 		startingSupplyHalf: 20,
 		startingWorkers:    4,
 		workerUnitID:       unitIDSCV,
-		workerTrainSeconds: 13,
+		workerTrainFrames:  300,
 		baseProducerCount:  1,
 	},
 	repcore.RaceProtoss.ID: {
 		startingSupplyHalf: 18,
 		startingWorkers:    4,
 		workerUnitID:       unitIDProbe,
-		workerTrainSeconds: 13,
+		workerTrainFrames:  300,
 		baseProducerCount:  1,
 	},
 	repcore.RaceZerg.ID: {
 		startingSupplyHalf: 18,
 		startingWorkers:    4,
 		workerUnitID:       unitIDDrone,
-		workerTrainSeconds: 13,
+		workerTrainFrames:  300,
 		baseProducerCount:  1,
+		usesLarva:          true,
 	},
 }
 
@@ -118,6 +130,8 @@ var supplyProvidedHalf = map[uint16]int{
 	unitIDOverlord:             16,
 }
 
+// unitSupplyCostHalf is the supply one train order uses. A Zergling or
+// Scourge order hatches two units at half a supply each.
 var unitSupplyCostHalf = map[uint16]int{
 	unitIDSCV:           2,
 	unitIDMarine:        2,
@@ -133,14 +147,14 @@ var unitSupplyCostHalf = map[uint16]int{
 	unitIDMedic:         2,
 	unitIDValkyrie:      6,
 	unitIDDrone:         2,
-	unitIDZergling:      1,
+	unitIDZergling:      2,
 	unitIDHydralisk:     2,
 	unitIDUltralisk:     8,
 	unitIDMutalisk:      4,
 	unitIDGuardian:      4,
 	unitIDQueen:         4,
 	unitIDDefiler:       4,
-	unitIDScourge:       1,
+	unitIDScourge:       2,
 	unitIDLurker:        4,
 	unitIDDevourer:      4,
 	unitIDProbe:         2,
@@ -159,18 +173,126 @@ var unitSupplyCostHalf = map[uint16]int{
 	unitIDDarkArchon:    8,
 }
 
-var unitBuildSeconds = map[uint16]int{
-	unitIDSCV:                  13,
-	unitIDProbe:                13,
-	unitIDDrone:                13,
-	repcmd.UnitIDSupplyDepot:   30,
-	repcmd.UnitIDPylon:         25,
-	unitIDOverlord:             25,
-	repcmd.UnitIDCommandCenter: 100,
-	repcmd.UnitIDNexus:         100,
-	repcmd.UnitIDHatchery:      120,
-	repcmd.UnitIDLair:          80,
-	repcmd.UnitIDHive:          120,
+const (
+	larvaSpawnFrames      = 342
+	maxLarvaPerHatchery   = 3
+	startingHatcheryLarva = 3
+)
+
+// larvaMorphUnits are the Zerg units that morph from a larva.
+var larvaMorphUnits = map[uint16]bool{
+	unitIDDrone:     true,
+	unitIDZergling:  true,
+	unitIDOverlord:  true,
+	unitIDHydralisk: true,
+	unitIDMutalisk:  true,
+	unitIDScourge:   true,
+	unitIDQueen:     true,
+	unitIDUltralisk: true,
+	unitIDDefiler:   true,
+}
+
+// unitBuildFrames holds Brood War build times in game frames.
+var unitBuildFrames = map[uint16]repcore.Frame{
+	unitIDSCV:                  300,
+	unitIDProbe:                300,
+	unitIDDrone:                300,
+	repcmd.UnitIDSupplyDepot:   600,
+	repcmd.UnitIDPylon:         450,
+	unitIDOverlord:             600,
+	repcmd.UnitIDCommandCenter: 1800,
+	repcmd.UnitIDNexus:         1800,
+	repcmd.UnitIDHatchery:      1800,
+	repcmd.UnitIDLair:          1500,
+	repcmd.UnitIDHive:          1800,
+	repcmd.UnitIDRefinery:      600,
+	repcmd.UnitIDExtractor:     600,
+	repcmd.UnitIDAssimilator:   600,
+
+	repcmd.UnitIDBarracks:         1200,
+	repcmd.UnitIDFactory:          1200,
+	repcmd.UnitIDStarport:         1050,
+	repcmd.UnitIDGateway:          900,
+	repcmd.UnitIDRoboticsFacility: 1200,
+	repcmd.UnitIDStargate:         1050,
+
+	repcmd.UnitIDAcademy:            1200,
+	repcmd.UnitIDEngineeringBay:     900,
+	repcmd.UnitIDArmory:             1200,
+	repcmd.UnitIDScienceFacility:    900,
+	repcmd.UnitIDMissileTurret:      450,
+	repcmd.UnitIDBunker:             450,
+	repcmd.UnitIDComSat:             600,
+	repcmd.UnitIDNuclearSilo:        600,
+	repcmd.UnitIDMachineShop:        600,
+	repcmd.UnitIDControlTower:       600,
+	repcmd.UnitIDCovertOps:          600,
+	repcmd.UnitIDPhysicsLab:         600,
+	repcmd.UnitIDForge:              600,
+	repcmd.UnitIDCyberneticsCore:    900,
+	repcmd.UnitIDPhotonCannon:       750,
+	repcmd.UnitIDShieldBattery:      450,
+	repcmd.UnitIDCitadelOfAdun:      900,
+	repcmd.UnitIDTemplarArchives:    900,
+	repcmd.UnitIDObservatory:        450,
+	repcmd.UnitIDRoboticsSupportBay: 450,
+	repcmd.UnitIDFleetBeacon:        900,
+	repcmd.UnitIDArbiterTribunal:    900,
+
+	repcmd.UnitIDSpawningPool:     1200,
+	repcmd.UnitIDEvolutionChamber: 600,
+	repcmd.UnitIDHydraliskDen:     600,
+	repcmd.UnitIDSpire:            1800,
+	repcmd.UnitIDGreaterSpire:     1800,
+	repcmd.UnitIDQueensNest:       900,
+	repcmd.UnitIDUltraliskCavern:  1200,
+	repcmd.UnitIDDefilerMound:     900,
+	repcmd.UnitIDNydusCanal:       600,
+	repcmd.UnitIDCreepColony:      300,
+	repcmd.UnitIDSunkenColony:     300,
+	repcmd.UnitIDSporeColony:      300,
+
+	unitIDMarine:        360,
+	unitIDFirebat:       360,
+	unitIDMedic:         450,
+	unitIDGhost:         750,
+	unitIDVulture:       450,
+	unitIDSiegeTankTM:   750,
+	unitIDGoliath:       600,
+	unitIDWraith:        900,
+	unitIDDropship:      750,
+	unitIDScienceVessel: 1200,
+	unitIDBattlecruiser: 2000,
+	unitIDValkyrie:      750,
+	unitIDZealot:        600,
+	unitIDDragoon:       750,
+	unitIDHighTemplar:   750,
+	unitIDDarkTemplar:   750,
+	unitIDShuttle:       900,
+	unitIDReaver:        1050,
+	unitIDObserver:      600,
+	unitIDScout:         1200,
+	unitIDCarrier:       2100,
+	unitIDArbiter:       2400,
+	unitIDCorsair:       600,
+
+	unitIDLurker:     600,
+	unitIDGuardian:   600,
+	unitIDDevourer:   600,
+	unitIDArchon:     300,
+	unitIDDarkArchon: 300,
+}
+
+// defaultTrainFrames is used for units without a known build time.
+var defaultTrainFrames = repcore.Duration2Frame(seconds(30))
+
+// buildFramesOf returns the build time of unitID, or defaultTrainFrames
+// when it isn't known.
+func buildFramesOf(unitID uint16) repcore.Frame {
+	if buildFrames, ok := unitBuildFrames[unitID]; ok {
+		return buildFrames
+	}
+	return defaultTrainFrames
 }
 
 // resolveScanTarget chooses auto aliases or a manual player name override.
@@ -181,6 +303,7 @@ func resolveScanTarget(identity PlayerIdentity, manualName string) ScanTarget {
 			DisplayLabel: manualName,
 			Names:        []string{manualName},
 			ManualName:   manualName,
+			Analysis:     AnalysisOptions{FilterIneffective: true},
 		}
 	}
 
@@ -197,62 +320,234 @@ func resolveScanTarget(identity PlayerIdentity, manualName string) ScanTarget {
 	return ScanTarget{
 		DisplayLabel: label,
 		Names:        append([]string(nil), identity.Aliases...),
+		Analysis:     AnalysisOptions{FilterIneffective: true},
 	}
 }
 
-// scanMacroStats scans replays for the selected target and aggregates macro metrics.
-func scanMacroStats(target ScanTarget, progressCallback func(float64)) (*MacroSummary, error) {
-	repFiles, err := findReplayFiles(progressCallback)
+// scanMacroStats scans replays for the selected target and aggregates macro
+// metrics. When ctx is done the scan stops early and the summary covers the
+// replays analyzed so far, marked Incomplete.
+func scanMacroStats(ctx context.Context, target ScanTarget, progressCallback func(float64)) (*MacroSummary, error) {
+	repFiles, err := findReplayFiles(ctx, target.Roots, progressCallback)
+	if ctx.Err() != nil {
+		summary := aggregateMacroResults(target, nil, 0)
+		summary.Incomplete = true
+		return summary, nil
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	results := make([]ReplayMacroResult, 0, len(repFiles))
-	skipped := 0
+	cache := loadReplayCache(target.CachePath)
+	summary := analyzeReplayFiles(ctx, target, repFiles, cache, progressCallback)
+	// A cache that can't be written only costs time on the next scan.
+	_ = cache.save()
+	return summary, nil
+}
+
+// headerPhaseShare is the part of the progress bar the header pass takes.
+const headerPhaseShare = 0.2
+
+// analyzeReplayFiles scans the replays in two passes. The first parses only
+// headers and settles every replay that is unreadable, filtered out, doesn't
+// have the target or is cached. The second parses the commands of the rest.
+func analyzeReplayFiles(ctx context.Context, target ScanTarget, repFiles []string, cache *replayCache, progressCallback func(float64)) *MacroSummary {
+	cacheKey := target.cacheKey()
+	workers := target.scanWorkers()
+	var cachedMu sync.Mutex
+	cached := 0
+
+	analyses, headerScanned := scanReplayFiles(ctx, repFiles, workers, func(ctx context.Context, repFile string) (replayAnalysis, error) {
+		analysis, hit := checkReplayHeader(repFile, target, cache, cacheKey)
+		if hit {
+			cachedMu.Lock()
+			cached++
+			cachedMu.Unlock()
+		}
+		return analysis, nil
+	}, scaleProgress(progressCallback, 0, headerPhaseShare))
+
+	var pending []int
+	var pendingFiles []string
+	for index, analysis := range analyses {
+		if analysis.NeedsParse {
+			pending = append(pending, index)
+			pendingFiles = append(pendingFiles, repFiles[index])
+		}
+	}
 
-	for index, repFile := range repFiles {
-		cfg := repparser.Config{Commands: true}
-		rep, err := repparser.ParseFileConfig(repFile, cfg)
+	parsed, parseScanned := scanReplayFiles(ctx, pendingFiles, workers, func(ctx context.Context, repFile string) (replayAnalysis, error) {
+		analysis, err := analyzeReplayFile(ctx, repFile, target)
 		if err != nil {
-			skipped++
-		} else {
-			player := findMatchingPlayer(rep, target.Names)
-			if player != nil {
-				results = append(results, analyzeMatchedReplay(rep, player))
-			}
+			return replayAnalysis{}, err
 		}
+		if info, err := os.Stat(repFile); err == nil {
+			cache.store(repFile, info, cacheKey, analysis)
+		}
+		if !target.Filter.allowsAnalysis(analysis) {
+			return replayAnalysis{Filtered: true}, nil
+		}
+		return analysis, nil
+	}, scaleProgress(progressCallback, headerPhaseShare, 1))
+	// Replays the second pass didn't get to stay unmatched.
+	for i, index := range pending {
+		analyses[index] = parsed[i]
+	}
 
-		if progressCallback != nil && len(repFiles) > 0 {
-			progressCallback(float64(index+1) / float64(len(repFiles)))
+	scanned := headerScanned - len(pending) + parseScanned
+	if len(pending) == 0 && scanned == len(repFiles) && progressCallback != nil {
+		progressCallback(1)
+	}
+
+	summary := summarizeReplayAnalyses(target, analyses)
+	summary.ScannedReplays = scanned
+	summary.CachedReplays = cached
+	summary.Incomplete = scanned < len(repFiles)
+	return summary
+}
+
+// checkReplayHeader is the first pass over one replay: it parses the header
+// only and tells whether the replay needs a full parse. The bool is true
+// when the analysis came from the cache.
+func checkReplayHeader(repFile string, target ScanTarget, cache *replayCache, cacheKey string) (replayAnalysis, bool) {
+	rep, err := repparser.ParseFileConfig(repFile, repparser.Config{})
+	if err != nil {
+		return replayAnalysis{Skipped: true}, false
+	}
+	// Only the target's games count as filtered.
+	if player, _ := findMatchingPlayer(rep, target.Names); player == nil {
+		return replayAnalysis{}, false
+	}
+	if !target.Filter.allowsHeader(rep.Header) {
+		return replayAnalysis{Filtered: true}, false
+	}
+
+	if info, err := os.Stat(repFile); err == nil {
+		if analysis, hit := cache.lookup(repFile, info, cacheKey); hit {
+			if !target.Filter.allowsAnalysis(analysis) {
+				return replayAnalysis{Filtered: true}, true
+			}
+			return analysis, true
 		}
 	}
+	return replayAnalysis{NeedsParse: true}, false
+}
+
+// scaleProgress maps a pass's progress from 0-1 onto from-to of the whole
+// scan.
+func scaleProgress(progressCallback func(float64), from, to float64) func(float64) {
+	if progressCallback == nil {
+		return nil
+	}
+	return func(p float64) {
+		progressCallback(from + p*(to-from))
+	}
+}
 
+// summarizeReplayAnalyses aggregates the matched replays in file order.
+func summarizeReplayAnalyses(target ScanTarget, analyses []replayAnalysis) *MacroSummary {
+	results := make([]ReplayMacroResult, 0, len(analyses))
+	skipped := 0
+	filtered := 0
+	for _, analysis := range analyses {
+		switch {
+		case analysis.Skipped:
+			skipped++
+		case analysis.Filtered:
+			filtered++
+		case analysis.Result.Matched:
+			results = append(results, analysis.Result)
+		}
+	}
 	summary := aggregateMacroResults(target, results, skipped)
-	summary.ScannedReplays = len(repFiles)
-	return summary, nil
+	summary.FilteredReplays = filtered
+	return summary
 }
 
-func findMatchingPlayer(rep *screp.Replay, names []string) *screp.Player {
+// analyzeReplayFile parses one replay and analyzes it for the target. It
+// returns ctx's error if ctx is done before the analysis finished.
+func analyzeReplayFile(ctx context.Context, repFile string, target ScanTarget) (replayAnalysis, error) {
+	if err := ctx.Err(); err != nil {
+		return replayAnalysis{}, err
+	}
+	cfg := repparser.Config{Commands: true}
+	rep, err := repparser.ParseFileConfig(repFile, cfg)
+	if err != nil {
+		return replayAnalysis{Skipped: true}, nil
+	}
+	// Parsing is the slow part; don't start the simulation after a cancel.
+	if err := ctx.Err(); err != nil {
+		return replayAnalysis{}, err
+	}
+	rep.Compute()
+	player, opponents := findMatchingPlayer(rep, target.Names)
+	if player == nil {
+		return replayAnalysis{}, nil
+	}
+	result := analyzeMatchedReplay(rep, player, target.Analysis)
+	result.Opponents = opponentNames(opponents)
+	return replayAnalysis{
+		TargetObserved: player.Observer,
+		ActivePlayers:  activePlayers(rep),
+		Result:         result,
+	}, nil
+}
+
+// findMatchingPlayer returns the first player with one of the names and that
+// player's opponents.
+func findMatchingPlayer(rep *screp.Replay, names []string) (*screp.Player, []*screp.Player) {
 	if rep == nil || rep.Header == nil {
-		return nil
+		return nil, nil
 	}
 
 	for _, player := range rep.Header.Players {
 		for _, name := range names {
 			if player.Name == name {
-				return player
+				return player, findOpponents(rep, player)
 			}
 		}
 	}
 
-	return nil
+	return nil, nil
 }
 
-func analyzeMatchedReplay(rep *screp.Replay, player *screp.Player) ReplayMacroResult {
+// findOpponents returns the players on other teams than player, leaving out
+// observers.
+func findOpponents(rep *screp.Replay, player *screp.Player) []*screp.Player {
+	var opponents []*screp.Player
+	for _, other := range rep.Header.Players {
+		if other.Observer || other.Team == player.Team {
+			continue
+		}
+		opponents = append(opponents, other)
+	}
+	return opponents
+}
+
+// opponentRace is the race letter of the first opponent, or 0 without one.
+func opponentRace(rep *screp.Replay, player *screp.Player) rune {
+	for _, opponent := range findOpponents(rep, player) {
+		if opponent.Race != nil {
+			return opponent.Race.Letter
+		}
+	}
+	return 0
+}
+
+func analyzeMatchedReplay(rep *screp.Replay, player *screp.Player, options AnalysisOptions) ReplayMacroResult {
 	result := ReplayMacroResult{
-		Matched:     true,
-		SupplyChart: make([]int, chartBucketCount),
-		WorkerChart: make([]int, chartBucketCount),
+		Matched:         true,
+		SupplyChart:     make([]int, chartBucketCount),
+		WorkerChart:     make([]int, chartBucketCount),
+		ResourceChart:   make([]int, chartBucketCount),
+		ProductionChart: make([]int, chartBucketCount),
+	}
+	fillMechanicsStats(&result, rep, player)
+	fillMatchup(&result, rep, player)
+	fillOutcome(&result, rep, player)
+	if rep != nil && rep.Header != nil {
+		result.MapName = normalizeMapName(rep.Header.Map)
+		result.StartTime = rep.Header.StartTime
 	}
 	if rep == nil || rep.Header == nil || rep.Commands == nil || player == nil || player.Race == nil {
 		return result
@@ -263,170 +558,452 @@ func analyzeMatchedReplay(rep *screp.Replay, player *screp.Player) ReplayMacroRe
 		return result
 	}
 
-	events := groupCommandsBySecond(rep.Commands.Cmds, player.ID)
-	duration := replayDurationSeconds(rep)
+	cmds := rep.Commands.Cmds
+	if options.FilterIneffective {
+		cmds = effectiveCommands(cmds)
+	}
+	events := groupCommandsByFrame(cmds, player.ID)
+	duration := replayDurationFrames(rep)
 	if duration <= 0 {
 		return result
 	}
 
 	state := replayState{
-		config:                config,
-		availableSupplyHalf:   config.startingSupplyHalf,
-		usedSupplyHalf:        config.startingWorkers * 2,
-		workerCount:           config.startingWorkers,
-		workerProducerCount:   config.baseProducerCount,
-		reachedWorkerCutoff:   false,
-		activeWorkerTrainEnds: nil,
-		pendingEvents:         map[int][]scheduledEvent{},
+		config:              config,
+		availableSupplyHalf: config.startingSupplyHalf,
+		usedSupplyHalf:      config.startingWorkers * 2,
+		workerCount:         config.startingWorkers,
+		reachedWorkerCutoff: false,
+		selection:           newSelectionTracker(),
+		bank:                resourceBank{minerals: startingMinerals},
+		upgradeLevels:       map[byte]int{},
+		armyProducers:       map[uint16][]*productionBuilding{},
+		pendingEvents:       map[repcore.Frame][]scheduledEvent{},
 	}
+	for i := 0; i < config.baseProducerCount; i++ {
+		producer := state.addWorkerProducer(0)
+		if config.usesLarva {
+			producer.larva = startingHatcheryLarva
+		}
+	}
+
+	supplyBlocked := newFrameCounter()
+	maxed := newFrameCounter()
+	workerIdle := newFrameCounter()
+	productionIdle := newFrameCounter()
+	unspent := newAverageSampler()
 
-	for second := 0; second <= duration; second++ {
-		state.applyScheduledEvents(second)
-		state.handleCommands(second, events[second])
+	for frame := repcore.Frame(0); frame <= duration; frame++ {
+		state.applyScheduledEvents(frame)
+		state.handleCommands(frame, events[frame])
 
-		if state.isSupplyBlocked(second) {
-			result.SupplyBlockedSeconds++
-			addChartSecond(result.SupplyChart, second)
+		if state.isSupplyBlocked(frame) {
+			supplyBlocked.add(frame, 1)
 		}
-		if state.isWorkerIdle() {
-			result.WorkerIdleSeconds++
-			addChartSecond(result.WorkerChart, second)
+		if state.isMaxed() {
+			maxed.add(frame, 1)
 		}
+		workerIdle.add(frame, state.idleWorkerProducers())
+		productionIdle.add(frame, state.idleArmyProducers())
+
+		state.gatherResources()
+		unspent.add(frame, state.bank.unspent())
 	}
 
+	result.SupplyBlockedSeconds = supplyBlocked.seconds()
+	result.SupplyChart = supplyBlocked.chart()
+	result.MaxedSeconds = maxed.seconds()
+	result.WorkerIdleSeconds = workerIdle.seconds()
+	result.WorkerChart = workerIdle.chart()
+	result.ProductionIdleSeconds = productionIdle.seconds()
+	result.ProductionChart = productionIdle.chart()
+	result.BuildOrder = state.buildOrder
+	result.Opening = classifyOpening(openingRules, player.Race.Letter, opponentRace(rep, player), state.buildOrder)
+	result.AvgUnspentResources = unspent.average()
+	result.ResourceChart = unspent.chart()
+	result.ResourceChartBuckets = unspent.sampledBuckets()
+
 	return result
 }
 
+// fillMechanicsStats copies the APM and EAPM screp computed for the player.
+// Replays parsed without rep.Compute() leave them at zero.
+func fillMechanicsStats(result *ReplayMacroResult, rep *screp.Replay, player *screp.Player) {
+	if rep == nil || rep.Computed == nil || player == nil {
+		return
+	}
+	desc := rep.Computed.PIDPlayerDescs[player.ID]
+	if desc == nil || desc.CmdCount == 0 {
+		return
+	}
+	result.APM = int(desc.APM)
+	result.EAPM = int(desc.EAPM)
+	result.IneffectiveRatio = float64(desc.CmdCount-desc.EffectiveCmdCount) / float64(desc.CmdCount)
+}
+
+// Game results of a matched replay, from the player's side.
+const (
+	outcomeWin     = "Win"
+	outcomeLoss    = "Loss"
+	outcomeUnknown = "Unknown"
+)
+
+// fillOutcome marks the replay as a win or loss from the winner team screp
+// computed. Without one the result stays unknown: screp only leaves it out
+// when the leave commands don't tell, for example after a disconnect.
+func fillOutcome(result *ReplayMacroResult, rep *screp.Replay, player *screp.Player) {
+	result.Outcome = outcomeUnknown
+	if rep == nil || rep.Computed == nil || player == nil {
+		return
+	}
+	if winner := rep.Computed.WinnerTeam; winner != 0 {
+		if player.Team == winner {
+			result.Outcome = outcomeWin
+		} else {
+			result.Outcome = outcomeLoss
+		}
+	}
+}
+
+// outcomeGroup names the summary group of a replay's result, leaving
+// unknown results out.
+func outcomeGroup(result ReplayMacroResult) string {
+	switch result.Outcome {
+	case outcomeWin:
+		return "Wins"
+	case outcomeLoss:
+		return "Losses"
+	}
+	return ""
+}
+
 type replayState struct {
-	config                raceConfig
-	availableSupplyHalf   int
-	usedSupplyHalf        int
-	workerCount           int
-	workerProducerCount   int
-	reachedWorkerCutoff   bool
-	supplyBlockedUntil    int
-	activeWorkerTrainEnds []int
-	pendingEvents         map[int][]scheduledEvent
-}
-
-func (s *replayState) handleCommands(second int, commands []commandEvent) {
+	config              raceConfig
+	availableSupplyHalf int
+	usedSupplyHalf      int
+	queuedSupplyHalf    int
+	workerCount         int
+	workerProducers     []*productionBuilding
+	armyProducers       map[uint16][]*productionBuilding
+	reachedWorkerCutoff bool
+	supplyBlockedUntil  repcore.Frame
+	selection           *selectionTracker
+	bank                resourceBank
+	refineries          int
+	upgradeLevels       map[byte]int
+	buildOrder          []BuildOrderEntry
+	orders              []*pendingOrder
+	nextOrderID         int
+	pendingEvents       map[repcore.Frame][]scheduledEvent
+}
+
+func (s *replayState) handleCommands(frame repcore.Frame, commands []commandEvent) {
 	for _, event := range commands {
+		s.selection.handle(event.command)
 		switch cmd := event.command.(type) {
 		case *repcmd.BuildCmd:
-			s.handleBuildCommand(second, cmd.Unit.ID)
+			s.handleBuildCommand(frame, cmd.Unit.ID)
 		case *repcmd.TrainCmd:
-			s.handleTrainCommand(second, cmd.Unit.ID)
+			s.handleTrainCommand(frame, cmd.Unit.ID)
 		case *repcmd.BuildingMorphCmd:
-			s.handleBuildCompletion(second, cmd.Unit.ID)
+			s.handleBuildCompletion(frame, cmd.Unit.ID)
+		case *repcmd.UpgradeCmd:
+			s.recordBuildOrder(frame, buildOrderKindUpgrade, cmd.Upgrade.Name)
+			s.handleUpgradeCommand(frame, cmd.Upgrade.ID, cmd.Upgrade.Name)
+		case *repcmd.TechCmd:
+			s.recordBuildOrder(frame, buildOrderKindTech, cmd.Tech.Name)
+			s.bank.spend(techCosts[cmd.Tech.ID])
+			s.startOrder(pendingOrder{
+				kind:      orderKindResearch,
+				doneFrame: frame + techResearchFrames[cmd.Tech.ID],
+				cost:      techCosts[cmd.Tech.ID],
+				name:      cmd.Tech.Name,
+			})
+		case *repcmd.CancelTrainCmd:
+			s.handleCancelCommand(frame, cmd)
+		case *repcmd.Base:
+			switch cmd.Type.ID {
+			case repcmd.TypeIDMergeArchon:
+				s.handleUnitMorph(frame, unitIDArchon)
+			case repcmd.TypeIDMergeDarkArchon:
+				s.handleUnitMorph(frame, unitIDDarkArchon)
+			default:
+				s.handleCancelCommand(frame, cmd)
+			}
 		}
 	}
 }
 
-func (s *replayState) handleBuildCommand(second int, unitID uint16) {
-	if unitID == repcmd.UnitIDHatchery || unitID == repcmd.UnitIDLair || unitID == repcmd.UnitIDHive {
+func (s *replayState) handleBuildCommand(frame repcore.Frame, unitID uint16) {
+	s.recordBuildOrder(frame, buildOrderKindBuild, unitName(unitID))
+	s.bank.spend(unitCosts[unitID])
+
+	usedDrone := false
+	if droneMorphBuildings[unitID] {
 		// Drone morph frees its occupied supply when it starts a building.
-		if s.config.workerUnitID == unitIDDrone && s.usedSupplyHalf >= 2 {
+		if s.config.workerUnitID == unitIDDrone && s.usedSupplyHalf >= 2 && s.workerCount > 0 {
 			s.usedSupplyHalf -= 2
-			if s.workerCount > 0 {
-				s.workerCount--
-			}
+			s.workerCount--
+			usedDrone = true
 		}
 	}
 
-	if buildSeconds, ok := unitBuildSeconds[unitID]; ok {
-		s.schedule(second+buildSeconds, scheduledEvent{second: second + buildSeconds, kind: "supply", unitID: unitID})
-		if unitID == repcmd.UnitIDCommandCenter || unitID == repcmd.UnitIDNexus || unitID == repcmd.UnitIDHatchery {
-			s.schedule(second+buildSeconds, scheduledEvent{second: second + buildSeconds, kind: "producer", unitID: unitID})
-		}
+	// Every building gets an order, so a cancel can't fall through to an
+	// older building still in progress.
+	doneFrame := frame + buildFramesOf(unitID)
+	order := s.startOrder(pendingOrder{
+		kind:      orderKindBuild,
+		unitID:    unitID,
+		doneFrame: doneFrame,
+		cost:      unitCosts[unitID],
+		usedDrone: usedDrone,
+		name:      unitName(unitID),
+	})
+	s.schedule(scheduledEvent{frame: doneFrame, kind: "supply", unitID: unitID, order: order.id})
+	if unitID == repcmd.UnitIDCommandCenter || unitID == repcmd.UnitIDNexus || unitID == repcmd.UnitIDHatchery {
+		s.schedule(scheduledEvent{frame: doneFrame, kind: "producer", unitID: unitID, order: order.id})
+	}
+	if armyProducerUnitIDs[unitID] {
+		s.schedule(scheduledEvent{frame: doneFrame, kind: "army-producer", unitID: unitID, order: order.id})
+	}
+	if refineryUnitIDs[unitID] {
+		s.schedule(scheduledEvent{frame: doneFrame, kind: "refinery", unitID: unitID, order: order.id})
 	}
 }
 
-func (s *replayState) handleTrainCommand(second int, unitID uint16) {
-	supplyCost := unitSupplyCostHalf[unitID]
-	if supplyCost > 0 && s.usedSupplyHalf >= s.availableSupplyHalf {
-		s.supplyBlockedUntil = s.nextSupplyReliefSecond(second)
+func (s *replayState) handleTrainCommand(frame repcore.Frame, unitID uint16) {
+	if _, ok := unitMorphs[unitID]; ok {
+		s.handleUnitMorph(frame, unitID)
+		return
 	}
 
-	buildSeconds := unitBuildSeconds[unitID]
-	if buildSeconds == 0 {
-		buildSeconds = 30
+	supplyCost := unitSupplyCostHalf[unitID]
+	if supplyCost > 0 && s.usedSupplyHalf >= s.supplyCapHalf() && !s.isMaxed() {
+		s.supplyBlockedUntil = s.nextSupplyReliefFrame(frame)
+	}
+	// Orders past 200 supply fail in game.
+	if supplyCost > 0 && s.usedSupplyHalf+s.queuedSupplyHalf+supplyCost > maxSupplyHalf {
+		return
 	}
 
-	if unitID == s.config.workerUnitID {
-		if s.activeWorkerTrains() < s.workerProducerCount && s.usedSupplyHalf+supplyCost <= s.availableSupplyHalf {
-			endSecond := second + buildSeconds
-			s.activeWorkerTrainEnds = append(s.activeWorkerTrainEnds, endSecond)
-			s.schedule(endSecond, scheduledEvent{second: endSecond, kind: "worker", unitID: unitID})
-			s.schedule(endSecond, scheduledEvent{second: endSecond, kind: "supply-used", unitID: unitID})
-		}
+	buildFrames := buildFramesOf(unitID)
+
+	isWorker := unitID == s.config.workerUnitID
+	if isWorker && s.usedSupplyHalf+supplyCost > s.supplyCapHalf() {
 		return
 	}
 
-	if supplyCost > 0 {
-		s.schedule(second+buildSeconds, scheduledEvent{second: second + buildSeconds, kind: "supply-used", unitID: unitID})
+	producerType, isArmy := armyProducerOf[unitID]
+
+	var producer, larva *productionBuilding
+	switch {
+	case s.config.usesLarva && larvaMorphUnits[unitID]:
+		larva = s.consumeLarva(frame)
+		if larva == nil {
+			return
+		}
+	case isWorker:
+		producer = producerFor(s.workerProducers, s.selection)
+		if producer == nil || producer.queueFull() {
+			return
+		}
+	case isArmy:
+		producer = producerFor(s.armyProducers[producerType], s.selection)
+		if producer == nil || producer.queueFull() {
+			return
+		}
 	}
 
-	if unitID == unitIDOverlord {
-		s.schedule(second+buildSeconds, scheduledEvent{second: second + buildSeconds, kind: "supply", unitID: unitID})
+	s.recordBuildOrder(frame, buildOrderKindTrain, unitName(unitID))
+	s.bank.spend(unitCosts[unitID])
+
+	s.queuedSupplyHalf += supplyCost
+	order := s.startOrder(pendingOrder{
+		kind:        orderKindTrain,
+		unitID:      unitID,
+		buildFrames: buildFrames,
+		producer:    producer,
+		larva:       larva,
+		cost:        unitCosts[unitID],
+		supplyHalf:  supplyCost,
+		name:        unitName(unitID),
+	})
+	if producer == nil {
+		s.startTraining(frame, order)
+		return
+	}
+	producer.queue = append(producer.queue, order)
+	if len(producer.queue) == 1 {
+		s.startTraining(frame, order)
 	}
 }
 
-func (s *replayState) handleBuildCompletion(second int, unitID uint16) {
+func (s *replayState) handleBuildCompletion(frame repcore.Frame, unitID uint16) {
+	s.recordBuildOrder(frame, buildOrderKindMorph, unitName(unitID))
+	s.bank.spend(unitCosts[unitID])
+	doneFrame := frame + buildFramesOf(unitID)
+	order := s.startOrder(pendingOrder{
+		kind:      orderKindMorph,
+		unitID:    unitID,
+		doneFrame: doneFrame,
+		cost:      unitCosts[unitID],
+		name:      unitName(unitID),
+	})
 	if unitID == repcmd.UnitIDLair || unitID == repcmd.UnitIDHive {
-		s.schedule(second+unitBuildSeconds[unitID], scheduledEvent{second: second + unitBuildSeconds[unitID], kind: "supply", unitID: unitID})
+		s.schedule(scheduledEvent{frame: doneFrame, kind: "supply", unitID: unitID, order: order.id})
 	}
 }
 
-func (s *replayState) applyScheduledEvents(second int) {
-	for _, event := range s.pendingEvents[second] {
+// handleUpgradeCommand pays for the next level of an upgrade. Levels are
+// counted from the orders, since replays don't record which level started.
+func (s *replayState) handleUpgradeCommand(frame repcore.Frame, upgradeID byte, name string) {
+	level := s.upgradeLevels[upgradeID] + 1
+	s.upgradeLevels[upgradeID] = level
+	cost := upgradeLevelCost(upgradeID, level)
+	s.bank.spend(cost)
+	s.startOrder(pendingOrder{
+		kind:      orderKindResearch,
+		doneFrame: frame + upgradeLevelResearchFrames(upgradeID, level),
+		cost:      cost,
+		upgradeID: upgradeID,
+		upgrade:   true,
+		name:      name,
+	})
+}
+
+func (s *replayState) gatherResources() {
+	s.bank.gather(s.workerCount, len(s.workerProducers), s.refineries)
+}
+
+func (s *replayState) applyScheduledEvents(frame repcore.Frame) {
+	for _, event := range s.pendingEvents[frame] {
 		switch event.kind {
 		case "supply":
 			s.availableSupplyHalf += supplyProvidedHalf[event.unitID]
-			if second >= s.supplyBlockedUntil {
+			if frame >= s.supplyBlockedUntil {
 				s.supplyBlockedUntil = 0
 			}
 		case "supply-used":
-			s.usedSupplyHalf += unitSupplyCostHalf[event.unitID]
+			s.usedSupplyHalf += event.supplyHalf
+			s.queuedSupplyHalf -= event.supplyHalf
 		case "worker":
 			s.workerCount++
-			s.activeWorkerTrainEnds = removeOneSecond(s.activeWorkerTrainEnds, second)
+			s.finishTraining(frame, event.producer)
 			if s.workerCount >= 60 {
 				s.reachedWorkerCutoff = true
 			}
 		case "producer":
-			s.workerProducerCount++
+			s.addWorkerProducer(frame)
+		case "refinery":
+			s.refineries++
+		case "army-producer":
+			s.addArmyProducer(event.unitID)
+		case "army":
+			s.finishTraining(frame, event.producer)
+		case "larva":
+			event.producer.larvaTimer = false
+			if event.producer.larva < maxLarvaPerHatchery {
+				event.producer.larva++
+			}
+			s.startLarvaTimer(frame, event.producer)
 		}
 	}
-	delete(s.pendingEvents, second)
+	delete(s.pendingEvents, frame)
 }
 
-func (s *replayState) schedule(second int, event scheduledEvent) {
-	s.pendingEvents[second] = append(s.pendingEvents[second], event)
+func (s *replayState) schedule(event scheduledEvent) {
+	s.pendingEvents[event.frame] = append(s.pendingEvents[event.frame], event)
 }
 
-func (s *replayState) activeWorkerTrains() int {
-	return len(s.activeWorkerTrainEnds)
+// addWorkerProducer registers a finished base. New hatcheries start without
+// larvae and spawn their first one after a full timer cycle.
+func (s *replayState) addWorkerProducer(frame repcore.Frame) *productionBuilding {
+	producer := &productionBuilding{}
+	s.workerProducers = append(s.workerProducers, producer)
+	if s.config.usesLarva {
+		s.startLarvaTimer(frame, producer)
+	}
+	return producer
+}
+
+// startLarvaTimer schedules the next larva for a hatchery below the larva cap.
+// The timer only runs while the hatchery has room for another larva.
+func (s *replayState) startLarvaTimer(frame repcore.Frame, producer *productionBuilding) {
+	if producer.larvaTimer || producer.larva >= maxLarvaPerHatchery {
+		return
+	}
+	producer.larvaTimer = true
+	s.schedule(scheduledEvent{frame: frame + larvaSpawnFrames, kind: "larva", producer: producer})
+}
+
+// consumeLarva uses a larva from the hatchery holding the most. Larva orders
+// can't be tied to a hatchery through the selection, since the selected
+// units are the larvae themselves.
+func (s *replayState) consumeLarva(frame repcore.Frame) *productionBuilding {
+	var best *productionBuilding
+	for _, producer := range s.workerProducers {
+		if producer.larva > 0 && (best == nil || producer.larva > best.larva) {
+			best = producer
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	best.larva--
+	s.startLarvaTimer(frame, best)
+	return best
 }
 
-func (s *replayState) isSupplyBlocked(second int) bool {
-	return s.supplyBlockedUntil > second
+// isSupplyBlocked reports a supply block. Being at 200 supply is reported as
+// maxed instead.
+func (s *replayState) isSupplyBlocked(frame repcore.Frame) bool {
+	return s.supplyBlockedUntil > frame && !s.isMaxed()
 }
 
-func (s *replayState) isWorkerIdle() bool {
-	return !s.reachedWorkerCutoff && s.workerProducerCount > 0 && s.activeWorkerTrains() == 0
+func (s *replayState) isMaxed() bool {
+	return s.usedSupplyHalf >= maxSupplyHalf
 }
 
-func (s *replayState) nextSupplyReliefSecond(current int) int {
+// supplyCapHalf is the supply the player can use: what their depots, pylons
+// and overlords provide, up to the 200 supply cap.
+func (s *replayState) supplyCapHalf() int {
+	if s.availableSupplyHalf > maxSupplyHalf {
+		return maxSupplyHalf
+	}
+	return s.availableSupplyHalf
+}
+
+// idleWorkerProducers counts producers without a worker in training, so two
+// idle bases accrue idle time twice as fast as one. A hatchery counts as idle
+// while it has a larva sitting unused.
+func (s *replayState) idleWorkerProducers() int {
+	if s.reachedWorkerCutoff {
+		return 0
+	}
+	idle := 0
+	for _, producer := range s.workerProducers {
+		if s.config.usesLarva {
+			if producer.larva > 0 {
+				idle++
+			}
+		} else if !producer.busy() {
+			idle++
+		}
+	}
+	return idle
+}
+
+func (s *replayState) nextSupplyReliefFrame(current repcore.Frame) repcore.Frame {
 	next := current + 1
 	found := false
-	for second, events := range s.pendingEvents {
-		if second <= current {
+	for frame, events := range s.pendingEvents {
+		if frame <= current {
 			continue
 		}
 		for _, event := range events {
 			if event.kind == "supply" && supplyProvidedHalf[event.unitID] > 0 {
-				if !found || second < next {
-					next = second
+				if !found || frame < next {
+					next = frame
 					found = true
 				}
 			}
@@ -435,13 +1012,67 @@ func (s *replayState) nextSupplyReliefSecond(current int) int {
 	return next
 }
 
+// frameCounter accumulates the frames a metric was active, both in total and
+// per chart bucket, and converts them to seconds only when read.
+type frameCounter struct {
+	total   int
+	buckets []int
+}
+
+func newFrameCounter() frameCounter {
+	return frameCounter{buckets: make([]int, chartBucketCount)}
+}
+
+func (c *frameCounter) add(frame repcore.Frame, count int) {
+	if count <= 0 {
+		return
+	}
+	c.total += count
+	addChartFrames(c.buckets, frame, count)
+}
+
+func (c frameCounter) seconds() int {
+	return framesToSeconds(c.total)
+}
+
+func (c frameCounter) chart() []int {
+	series := make([]int, len(c.buckets))
+	for i, frames := range c.buckets {
+		series[i] = framesToSeconds(frames)
+	}
+	return series
+}
+
 func aggregateMacroResults(target ScanTarget, results []ReplayMacroResult, skippedReplays int) *MacroSummary {
+	summary := summarizeMacroMetrics(target.DisplayLabel, results)
+	summary.SkippedReplays = skippedReplays
+	summary.Openings = summarizeOpenings(results)
+	summary.Outcomes = breakdownMacroResults(results, outcomeGroup)
+	summary.Maps = summarizeMaps(results)
+	summary.Opponents = summarizeOpponents(results)
+	for _, period := range trendPeriods {
+		summary.Trends = append(summary.Trends, buildTrend(results, period))
+	}
+	summary.RecentChange = recentTrendChange(results, trendChangeDays)
+	summary.Matchups = breakdownMacroResults(results, func(result ReplayMacroResult) string {
+		return result.Matchup
+	})
+	return summary
+}
+
+// summarizeMacroMetrics averages and rates the macro metrics of the matched
+// replays.
+func summarizeMacroMetrics(label string, results []ReplayMacroResult) *MacroSummary {
 	summary := &MacroSummary{
-		TargetLabel:    target.DisplayLabel,
-		SkippedReplays: skippedReplays,
-		SupplyChart:    make([]int, chartBucketCount),
-		WorkerChart:    make([]int, chartBucketCount),
+		TargetLabel:     label,
+		SupplyChart:     make([]int, chartBucketCount),
+		WorkerChart:     make([]int, chartBucketCount),
+		ProductionChart: make([]int, chartBucketCount),
+		ResourceChart:   make([]int, chartBucketCount),
 	}
+	totalUnspent := 0
+	mechanicsReplays := 0
+	resourceSamples := make([]int, chartBucketCount)
 
 	for _, result := range results {
 		if !result.Matched {
@@ -449,19 +1080,51 @@ func aggregateMacroResults(target ScanTarget, results []ReplayMacroResult, skipp
 		}
 		summary.MatchedReplays++
 		summary.TotalSupplyBlockedSeconds += result.SupplyBlockedSeconds
+		summary.TotalMaxedSeconds += result.MaxedSeconds
 		summary.TotalWorkerIdleSeconds += result.WorkerIdleSeconds
+		summary.TotalProductionIdleSeconds += result.ProductionIdleSeconds
 		addSeries(summary.SupplyChart, result.SupplyChart)
 		addSeries(summary.WorkerChart, result.WorkerChart)
+		addSeries(summary.ProductionChart, result.ProductionChart)
+		addSeries(summary.ResourceChart, result.ResourceChart)
+		for i := 0; i < result.ResourceChartBuckets && i < chartBucketCount; i++ {
+			resourceSamples[i]++
+		}
+		totalUnspent += result.AvgUnspentResources
+		if result.APM > 0 {
+			mechanicsReplays++
+			summary.AvgAPM += float64(result.APM)
+			summary.AvgEAPM += float64(result.EAPM)
+			summary.AvgIneffectiveRatio += result.IneffectiveRatio
+		}
+	}
+
+	// APM is only averaged over replays that have computed stats.
+	if mechanicsReplays > 0 {
+		summary.AvgAPM /= float64(mechanicsReplays)
+		summary.AvgEAPM /= float64(mechanicsReplays)
+		summary.AvgIneffectiveRatio /= float64(mechanicsReplays)
 	}
 
 	if summary.MatchedReplays > 0 {
 		summary.AvgSupplyBlockedSeconds = float64(summary.TotalSupplyBlockedSeconds) / float64(summary.MatchedReplays)
+		summary.AvgMaxedSeconds = float64(summary.TotalMaxedSeconds) / float64(summary.MatchedReplays)
 		summary.AvgWorkerIdleSeconds = float64(summary.TotalWorkerIdleSeconds) / float64(summary.MatchedReplays)
 		summary.SupplyRating = rateMetric(summary.AvgSupplyBlockedSeconds, supplyGreatThreshold, supplySolidThreshold)
 		summary.WorkerRating = rateMetric(summary.AvgWorkerIdleSeconds, workerGreatThreshold, workerSolidThreshold)
+		summary.AvgProductionIdleSeconds = float64(summary.TotalProductionIdleSeconds) / float64(summary.MatchedReplays)
+		summary.ProductionRating = rateMetric(summary.AvgProductionIdleSeconds, productionGreatThreshold, productionSolidThreshold)
+		summary.AvgUnspentResources = float64(totalUnspent) / float64(summary.MatchedReplays)
+		summary.ResourceRating = rateMetric(summary.AvgUnspentResources, floatGreatThreshold, floatSolidThreshold)
+		// Unspent resources are a level, not a duration, so the chart shows
+		// the average over the replays that lasted into each bucket rather
+		// than a sum.
+		divideSeries(summary.ResourceChart, resourceSamples)
 	} else {
 		summary.SupplyRating = "No Data"
 		summary.WorkerRating = "No Data"
+		summary.ProductionRating = "No Data"
+		summary.ResourceRating = "No Data"
 	}
 
 	return summary
@@ -478,30 +1141,105 @@ func rateMetric(avg, great, solid float64) string {
 	}
 }
 
-func groupCommandsBySecond(cmds []repcmd.Cmd, playerID byte) map[int][]commandEvent {
-	result := make(map[int][]commandEvent)
+// effectiveCommands drops the commands screp flags as ineffective, such as
+// train orders past a full queue and repeated morph or build orders, so spam
+// doesn't reach the simulation. Fast cancels and fast reselections are kept:
+// they count against EAPM but still changed the game. The flags are set by
+// rep.Compute().
+func effectiveCommands(cmds []repcmd.Cmd) []repcmd.Cmd {
+	result := make([]repcmd.Cmd, 0, len(cmds))
+	for _, cmd := range cmds {
+		switch cmd.BaseCmd().IneffKind {
+		case repcore.IneffKindEffective, repcore.IneffKindFastCancel, repcore.IneffKindFastReselection:
+			result = append(result, cmd)
+		}
+	}
+	return result
+}
+
+func groupCommandsByFrame(cmds []repcmd.Cmd, playerID byte) map[repcore.Frame][]commandEvent {
+	result := make(map[repcore.Frame][]commandEvent)
 	for _, cmd := range cmds {
 		if cmd.BaseCmd().PlayerID != playerID {
 			continue
 		}
-		second := int(cmd.BaseCmd().Frame.Duration().Seconds())
-		result[second] = append(result[second], commandEvent{second: second, command: cmd})
+		frame := cmd.BaseCmd().Frame
+		result[frame] = append(result[frame], commandEvent{frame: frame, command: cmd})
 	}
 	return result
 }
 
-func replayDurationSeconds(rep *screp.Replay) int {
+func replayDurationFrames(rep *screp.Replay) repcore.Frame {
 	if rep == nil || rep.Header == nil {
 		return 0
 	}
-	return int(rep.Header.Frames.Duration().Seconds())
+	return rep.Header.Frames
 }
 
-func addChartSecond(series []int, second int) {
-	if second < 0 || second >= chartWindowSeconds {
+// averageSampler averages a per-frame value over the whole game and per
+// chart bucket.
+type averageSampler struct {
+	total         float64
+	samples       int
+	bucketTotals  []float64
+	bucketSamples []int
+}
+
+func newAverageSampler() averageSampler {
+	return averageSampler{
+		bucketTotals:  make([]float64, chartBucketCount),
+		bucketSamples: make([]int, chartBucketCount),
+	}
+}
+
+func (a *averageSampler) add(frame repcore.Frame, value float64) {
+	a.total += value
+	a.samples++
+
+	elapsed := frame.Duration()
+	if elapsed < 0 || elapsed >= seconds(chartWindowSeconds) {
+		return
+	}
+	bucket := int(elapsed / seconds(chartBucketSeconds))
+	a.bucketTotals[bucket] += value
+	a.bucketSamples[bucket]++
+}
+
+func (a averageSampler) average() int {
+	if a.samples == 0 {
+		return 0
+	}
+	return int(math.Round(a.total / float64(a.samples)))
+}
+
+// sampledBuckets counts the chart buckets with samples. Samples are taken
+// every frame, so they are the first buckets up to the end of the replay.
+func (a averageSampler) sampledBuckets() int {
+	buckets := 0
+	for i, samples := range a.bucketSamples {
+		if samples > 0 {
+			buckets = i + 1
+		}
+	}
+	return buckets
+}
+
+func (a averageSampler) chart() []int {
+	series := make([]int, len(a.bucketTotals))
+	for i, total := range a.bucketTotals {
+		if a.bucketSamples[i] > 0 {
+			series[i] = int(math.Round(total / float64(a.bucketSamples[i])))
+		}
+	}
+	return series
+}
+
+func addChartFrames(series []int, frame repcore.Frame, count int) {
+	elapsed := frame.Duration()
+	if elapsed < 0 || elapsed >= seconds(chartWindowSeconds) {
 		return
 	}
-	series[second/chartBucketSeconds]++
+	series[int(elapsed/seconds(chartBucketSeconds))] += count
 }
 
 func addSeries(dst, src []int) {
@@ -512,13 +1250,18 @@ func addSeries(dst, src []int) {
 	}
 }
 
-func removeOneSecond(values []int, target int) []int {
-	for i, value := range values {
-		if value == target {
-			return append(values[:i], values[i+1:]...)
+// divideSeries divides each bucket by its own divisor, leaving buckets
+// without one alone.
+func divideSeries(series, divisors []int) {
+	for i := range series {
+		if i < len(divisors) && divisors[i] > 0 {
+			series[i] = int(math.Round(float64(series[i]) / float64(divisors[i])))
 		}
 	}
-	return values
+}
+
+func framesToSeconds(frames int) int {
+	return int(math.Round(repcore.Frame(frames).Seconds()))
 }
 
 func seconds(n int) time.Duration {
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"math"
+	"reflect"
 	"testing"
 
 	screp "github.com/icza/screp/rep"
@@ -21,7 +23,7 @@ func TestAnalyzeReplaySupplyBlockLateDepot(t *testing.T) {
 	}, 120)
 
 	player := rep.Header.Players[0]
-	result := analyzeMatchedReplay(rep, player)
+	result := analyzeMatchedReplay(rep, player, AnalysisOptions{})
 
 	if result.SupplyBlockedSeconds == 0 {
 		t.Fatalf("expected supply block time, got 0")
@@ -44,20 +46,47 @@ func TestAnalyzeReplaySupplyBlockTimelyDepot(t *testing.T) {
 	}, 120)
 
 	player := rep.Header.Players[0]
-	result := analyzeMatchedReplay(rep, player)
+	result := analyzeMatchedReplay(rep, player, AnalysisOptions{})
 
 	if result.SupplyBlockedSeconds != 0 {
 		t.Fatalf("expected no supply block time, got %d", result.SupplyBlockedSeconds)
 	}
 }
 
+func TestReplayStateMaxedIsNotSupplyBlock(t *testing.T) {
+	state := newTestReplayState(repcore.RaceTerran)
+	state.availableSupplyHalf = 432
+	state.usedSupplyHalf = 400
+	state.addArmyProducer(repcmd.UnitIDBarracks)
+
+	state.handleTrainCommand(100, unitIDMarine)
+
+	if state.supplyCapHalf() != maxSupplyHalf {
+		t.Fatalf("expected supply capped at %d, got %d", maxSupplyHalf, state.supplyCapHalf())
+	}
+	if !state.isMaxed() || state.isSupplyBlocked(101) {
+		t.Fatalf("expected a maxed player not to be supply blocked")
+	}
+	if state.queuedSupplyHalf != 0 {
+		t.Fatalf("expected the order past 200 supply to be dropped, got %d queued", state.queuedSupplyHalf)
+	}
+
+	state.usedSupplyHalf = 40
+	state.availableSupplyHalf = 40
+	state.handleTrainCommand(200, unitIDMarine)
+
+	if state.isMaxed() || !state.isSupplyBlocked(200) {
+		t.Fatalf("expected a block below 200 supply")
+	}
+}
+
 func TestAnalyzeReplayWorkerIdleAndChart(t *testing.T) {
 	rep := terranReplayWithCommands([]timedCmd{
 		buildWorker(30),
 	}, 120)
 
 	player := rep.Header.Players[0]
-	result := analyzeMatchedReplay(rep, player)
+	result := analyzeMatchedReplay(rep, player, AnalysisOptions{})
 
 	if result.WorkerIdleSeconds < 30 {
 		t.Fatalf("expected worker idle time before first worker, got %d", result.WorkerIdleSeconds)
@@ -69,36 +98,519 @@ func TestAnalyzeReplayWorkerIdleAndChart(t *testing.T) {
 
 func TestAnalyzeReplayStopsWorkerIdleAfterSixtyWorkers(t *testing.T) {
 	var cmds []timedCmd
-	for second := 0; second < 13*56; second += 13 {
-		cmds = append(cmds, buildWorker(second))
+	for frame := repcore.Frame(0); frame < 300*56; frame += 300 {
+		cmds = append(cmds, buildWorkerAtFrame(frame))
 	}
-	for _, second := range []int{40, 150, 256, 360, 464, 568, 672} {
-		cmds = append(cmds, buildBuilding(second, repcmd.UnitIDSupplyDepot))
+	// Each depot covers the next eight SCVs.
+	for frame := repcore.Frame(1000); frame < 300*56; frame += 2400 {
+		cmds = append(cmds, buildBuildingAtFrame(frame, repcmd.UnitIDSupplyDepot))
 	}
 
 	rep := terranReplayWithCommands(cmds, 1000)
 	player := rep.Header.Players[0]
-	result := analyzeMatchedReplay(rep, player)
+	result := analyzeMatchedReplay(rep, player, AnalysisOptions{})
 
 	if result.WorkerIdleSeconds != 0 {
 		t.Fatalf("expected no worker idle after continuous worker production to 60, got %d", result.WorkerIdleSeconds)
 	}
 }
 
+func TestAnalyzeReplayCountsSubSecondSupplyBlock(t *testing.T) {
+	// Six SCVs back to back bring supply to 10/10 at frame 1800; the depot
+	// finishes 20 frames later, which a whole-second simulation rounds away.
+	var cmds []timedCmd
+	for frame := repcore.Frame(0); frame <= 1500; frame += 300 {
+		cmds = append(cmds, buildWorkerAtFrame(frame))
+	}
+	cmds = append(cmds,
+		buildBuildingAtFrame(1220, repcmd.UnitIDSupplyDepot),
+		buildWorkerAtFrame(1800),
+	)
+
+	rep := terranReplayWithCommands(cmds, 120)
+	player := rep.Header.Players[0]
+	result := analyzeMatchedReplay(rep, player, AnalysisOptions{})
+
+	if result.SupplyBlockedSeconds != 1 {
+		t.Fatalf("expected a sub-second block rounded to 1s, got %d", result.SupplyBlockedSeconds)
+	}
+	if result.SupplyChart[2] != 1 {
+		t.Fatalf("expected the block in the 60-90s bucket, got %v", result.SupplyChart)
+	}
+}
+
+func TestAnalyzeReplayWorkerIdlePerBase(t *testing.T) {
+	const mainCC, naturalCC repcmd.UnitTag = 0x0100, 0x0200
+
+	cmds := []timedCmd{
+		buildBuildingAtFrame(0, repcmd.UnitIDCommandCenter),
+	}
+	// Every SCV is trained from the main, so the natural sits idle once it
+	// finishes at frame 1800.
+	for frame := repcore.Frame(0); frame < 2858; frame += 300 {
+		cmds = append(cmds,
+			selectUnitsAtFrame(frame, mainCC),
+			buildWorkerAtFrame(frame),
+		)
+	}
+	cmds = append(cmds, selectUnitsAtFrame(2000, naturalCC))
+
+	rep := terranReplayWithCommands(cmds, 120)
+	player := rep.Header.Players[0]
+	result := analyzeMatchedReplay(rep, player, AnalysisOptions{})
+
+	if result.WorkerIdleSeconds < 40 || result.WorkerIdleSeconds > 48 {
+		t.Fatalf("expected about 44s of natural idle time, got %d", result.WorkerIdleSeconds)
+	}
+}
+
+func TestAnalyzeReplayTrainsFromHotkeyedBaseOnly(t *testing.T) {
+	const mainCC, naturalCC repcmd.UnitTag = 0x0100, 0x0200
+
+	cmds := []timedCmd{
+		buildBuildingAtFrame(0, repcmd.UnitIDCommandCenter),
+		selectUnitsAtFrame(1, mainCC),
+		hotkeyAtFrame(2, repcmd.HotkeyTypeIDAssign, 4),
+		selectUnitsAtFrame(1800, naturalCC),
+		hotkeyAtFrame(1801, repcmd.HotkeyTypeIDAssign, 5),
+	}
+	// Orders are spammed at the natural through its hotkey; the repeat order
+	// queues there and must not leak to the idle main as it would with a
+	// shared pool.
+	for frame := repcore.Frame(1810); frame < 2858; frame += 300 {
+		cmds = append(cmds,
+			hotkeyAtFrame(frame, repcmd.HotkeyTypeIDSelect, 5),
+			buildWorkerAtFrame(frame),
+			buildWorkerAtFrame(frame+1),
+		)
+	}
+
+	rep := terranReplayWithCommands(cmds, 120)
+	player := rep.Header.Players[0]
+	result := analyzeMatchedReplay(rep, player, AnalysisOptions{})
+
+	// The main idles for the whole game and the natural for ten frames.
+	if result.WorkerIdleSeconds != 120 {
+		t.Fatalf("expected only the natural to train, got %ds idle", result.WorkerIdleSeconds)
+	}
+}
+
+func TestAnalyzeReplayQueuedWorkersLeaveNoIdleTime(t *testing.T) {
+	var cmds []timedCmd
+	for i := 0; i < maxProductionQueue+1; i++ {
+		cmds = append(cmds, buildWorkerAtFrame(0))
+	}
+	rep := terranReplayWithCommands(cmds, 60)
+	result := analyzeMatchedReplay(rep, rep.Header.Players[0], AnalysisOptions{})
+
+	// Five queued SCVs keep the Command Center busy for 1500 frames, past the
+	// end of the replay; the sixth order doesn't fit in the queue.
+	if result.WorkerIdleSeconds != 0 {
+		t.Fatalf("expected no idle time while the queue runs, got %ds", result.WorkerIdleSeconds)
+	}
+	if len(result.BuildOrder) != maxProductionQueue {
+		t.Fatalf("expected %d queued SCVs, got %+v", maxProductionQueue, result.BuildOrder)
+	}
+}
+
+func TestAnalyzeReplayCancelQueuedWorkerKeepsTraining(t *testing.T) {
+	single := terranReplayWithCommands([]timedCmd{buildWorkerAtFrame(0)}, 60)
+	singleResult := analyzeMatchedReplay(single, single.Header.Players[0], AnalysisOptions{})
+
+	canceled := terranReplayWithCommands([]timedCmd{
+		buildWorkerAtFrame(0),
+		buildWorkerAtFrame(0),
+		baseCmdAtFrame(60, repcmd.TypeIDCancelTrain),
+	}, 60)
+	canceledResult := analyzeMatchedReplay(canceled, canceled.Header.Players[0], AnalysisOptions{})
+
+	// Canceling takes the queued SCV out; the one in training still finishes.
+	if canceledResult.WorkerIdleSeconds != singleResult.WorkerIdleSeconds {
+		t.Fatalf("expected %ds idle like a single SCV, got %ds",
+			singleResult.WorkerIdleSeconds, canceledResult.WorkerIdleSeconds)
+	}
+}
+
+func TestAnalyzeReplayZergUnusedLarvaIsIdle(t *testing.T) {
+	rep := zergReplayWithCommands(nil, 60)
+	player := rep.Header.Players[0]
+	result := analyzeMatchedReplay(rep, player, AnalysisOptions{})
+
+	if result.WorkerIdleSeconds != 60 {
+		t.Fatalf("expected the full game as larva idle time, got %d", result.WorkerIdleSeconds)
+	}
+}
+
+func TestAnalyzeReplayZergDronesUseLarva(t *testing.T) {
+	// Three starting larvae go at once, then each new larva is used as it
+	// spawns until the hatchery's 9 supply runs out.
+	rep := zergReplayWithCommands([]timedCmd{
+		morphUnitAtFrame(0, unitIDDrone),
+		morphUnitAtFrame(1, unitIDDrone),
+		morphUnitAtFrame(2, unitIDDrone),
+		morphUnitAtFrame(100, unitIDDrone),
+		morphUnitAtFrame(342, unitIDDrone),
+		morphUnitAtFrame(684, unitIDDrone),
+	}, 60)
+	player := rep.Header.Players[0]
+	result := analyzeMatchedReplay(rep, player, AnalysisOptions{})
+
+	// Idle for frames 0-1 and from the larva at 1026 to the end; the drone
+	// at frame 100 has no larva and is dropped.
+	if result.WorkerIdleSeconds != 17 {
+		t.Fatalf("expected 17s of larva idle time, got %d", result.WorkerIdleSeconds)
+	}
+}
+
+func TestReplayStateQueenUsesLarva(t *testing.T) {
+	state := newTestReplayState(repcore.RaceZerg)
+	state.availableSupplyHalf = 18
+	state.usedSupplyHalf = 8
+	hatchery := state.addWorkerProducer(0)
+	hatchery.larva = startingHatcheryLarva
+
+	state.handleTrainCommand(0, unitIDQueen)
+
+	if hatchery.larva != startingHatcheryLarva-1 || len(state.orders) != 1 || state.orders[0].larva != hatchery {
+		t.Fatalf("expected the Queen to morph from a larva, got %d larvae left", hatchery.larva)
+	}
+}
+
+func TestAnalyzeReplayProductionIdle(t *testing.T) {
+	idle := terranReplayWithCommands([]timedCmd{
+		buildBuildingAtFrame(0, repcmd.UnitIDBarracks),
+	}, 120)
+	idleResult := analyzeMatchedReplay(idle, idle.Header.Players[0], AnalysisOptions{})
+
+	// The Barracks finishes at frame 1200 and idles until frame 2858.
+	if idleResult.ProductionIdleSeconds != 70 {
+		t.Fatalf("expected 70s of Barracks idle time, got %d", idleResult.ProductionIdleSeconds)
+	}
+	if idleResult.ProductionChart[0] != 0 || idleResult.ProductionChart[3] == 0 {
+		t.Fatalf("expected idle time only after the Barracks finishes, got %v", idleResult.ProductionChart)
+	}
+
+	cmds := []timedCmd{buildBuildingAtFrame(0, repcmd.UnitIDBarracks)}
+	for frame := repcore.Frame(1200); frame < 2858; frame += 360 {
+		cmds = append(cmds, trainUnitAtFrame(frame, unitIDMarine))
+	}
+	busy := terranReplayWithCommands(cmds, 120)
+	busyResult := analyzeMatchedReplay(busy, busy.Header.Players[0], AnalysisOptions{})
+
+	if busyResult.ProductionIdleSeconds != 0 {
+		t.Fatalf("expected constant Marine production to leave no idle time, got %d", busyResult.ProductionIdleSeconds)
+	}
+}
+
+func TestAnalyzeReplayUnspentResources(t *testing.T) {
+	idle := terranReplayWithCommands(nil, 120)
+	idleResult := analyzeMatchedReplay(idle, idle.Header.Players[0], AnalysisOptions{})
+
+	var cmds []timedCmd
+	for frame := repcore.Frame(0); frame < 2858; frame += 300 {
+		cmds = append(cmds, buildWorkerAtFrame(frame))
+	}
+	cmds = append(cmds, buildBuildingAtFrame(1200, repcmd.UnitIDSupplyDepot))
+	spending := terranReplayWithCommands(cmds, 120)
+	spendingResult := analyzeMatchedReplay(spending, spending.Header.Players[0], AnalysisOptions{})
+
+	if idleResult.AvgUnspentResources <= spendingResult.AvgUnspentResources {
+		t.Fatalf("expected floating money without spending, got %d vs %d",
+			idleResult.AvgUnspentResources, spendingResult.AvgUnspentResources)
+	}
+	if idleResult.ResourceChart[3] <= idleResult.ResourceChart[0] {
+		t.Fatalf("expected the unspent bank to grow, got %v", idleResult.ResourceChart)
+	}
+}
+
+func TestAnalyzeReplayCanceledDepotGivesNoSupply(t *testing.T) {
+	var cmds []timedCmd
+	for frame := repcore.Frame(0); frame < 2400; frame += 300 {
+		cmds = append(cmds, buildWorkerAtFrame(frame))
+	}
+	cmds = append(cmds,
+		buildBuildingAtFrame(1200, repcmd.UnitIDSupplyDepot),
+		buildBuildingAtFrame(1500, repcmd.UnitIDSupplyDepot),
+	)
+	kept := terranReplayWithCommands(cmds, 120)
+	keptResult := analyzeMatchedReplay(kept, kept.Header.Players[0], AnalysisOptions{})
+
+	cmds = append(cmds, baseCmdAtFrame(1300, repcmd.TypeIDCancelBuild))
+	canceled := terranReplayWithCommands(cmds, 120)
+	canceledResult := analyzeMatchedReplay(canceled, canceled.Header.Players[0], AnalysisOptions{})
+
+	if keptResult.SupplyBlockedSeconds != 0 {
+		t.Fatalf("expected the depot to prevent a block, got %ds", keptResult.SupplyBlockedSeconds)
+	}
+	// The SCV ordered at frame 1800 waits for the second depot at 2100.
+	if canceledResult.SupplyBlockedSeconds != 13 {
+		t.Fatalf("expected a 13s block after canceling the first depot, got %ds", canceledResult.SupplyBlockedSeconds)
+	}
+	if entry := canceledResult.BuildOrder[6]; entry.Kind != buildOrderKindCancel || entry.Action != "Supply Depot" {
+		t.Fatalf("expected the cancel in the build order, got %+v", canceledResult.BuildOrder)
+	}
+}
+
+func TestAnalyzeReplayCanceledWorkerLeavesBaseIdle(t *testing.T) {
+	kept := terranReplayWithCommands([]timedCmd{buildWorkerAtFrame(0)}, 60)
+	keptResult := analyzeMatchedReplay(kept, kept.Header.Players[0], AnalysisOptions{})
+
+	canceled := terranReplayWithCommands([]timedCmd{
+		buildWorkerAtFrame(0),
+		baseCmdAtFrame(60, repcmd.TypeIDCancelTrain),
+	}, 60)
+	canceledResult := analyzeMatchedReplay(canceled, canceled.Header.Players[0], AnalysisOptions{})
+
+	// The Command Center idles from frame 60 instead of frame 300; each total
+	// is rounded on its own.
+	if got, want := canceledResult.WorkerIdleSeconds-keptResult.WorkerIdleSeconds, 11; got != want {
+		t.Fatalf("expected %ds more idle time after the cancel, got %d", want, got)
+	}
+
+	retrained := terranReplayWithCommands([]timedCmd{
+		buildWorkerAtFrame(0),
+		baseCmdAtFrame(60, repcmd.TypeIDCancelTrain),
+		buildWorkerAtFrame(120),
+	}, 60)
+	retrainedResult := analyzeMatchedReplay(retrained, retrained.Header.Players[0], AnalysisOptions{})
+
+	// Without the cancel the order at frame 120 would go to a busy base.
+	if len(retrainedResult.BuildOrder) != 3 || retrainedResult.BuildOrder[2].Supply != 4 {
+		t.Fatalf("expected the retrained SCV at 4 supply, got %+v", retrainedResult.BuildOrder)
+	}
+}
+
+func TestReplayStateCancelUpgradeRefunds(t *testing.T) {
+	state := newTestReplayState(repcore.RaceTerran)
+	state.bank = resourceBank{minerals: 500, gas: 500}
+	// Terran Infantry Weapons.
+	state.handleUpgradeCommand(0, 0x07, "Terran Infantry Weapons")
+	state.handleCancelCommand(10, baseCmdAtFrame(10, repcmd.TypeIDCancelUpgrade).toCmd(1))
+
+	if state.upgradeLevels[0x07] != 0 || state.bank.minerals != 500 || state.bank.gas != 500 {
+		t.Fatalf("expected the upgrade to be undone and refunded, got level %d and %+v",
+			state.upgradeLevels[0x07], state.bank)
+	}
+}
+
+func TestReplayStateCancelUpgradeAfterResearchFinishes(t *testing.T) {
+	state := newTestReplayState(repcore.RaceTerran)
+	state.bank = resourceBank{minerals: 500, gas: 500}
+	// Terran Infantry Weapons level 1 takes 4000 frames.
+	state.handleUpgradeCommand(0, 0x07, "Terran Infantry Weapons")
+	state.handleCancelCommand(4000, baseCmdAtFrame(4000, repcmd.TypeIDCancelUpgrade).toCmd(1))
+
+	if state.upgradeLevels[0x07] != 1 || state.bank.minerals != 400 || len(state.orders) != 0 {
+		t.Fatalf("expected the finished upgrade to be kept and forgotten, got level %d, %+v and %d orders",
+			state.upgradeLevels[0x07], state.bank, len(state.orders))
+	}
+}
+
+func TestReplayStateCancelBuildLeavesUnitMorphs(t *testing.T) {
+	state := newTestReplayState(repcore.RaceZerg)
+	state.availableSupplyHalf = 36
+	state.usedSupplyHalf = 20
+	state.bank = resourceBank{minerals: 1000, gas: 1000}
+	state.handleBuildCommand(0, repcmd.UnitIDHatchery)
+	state.handleUnitMorph(10, unitIDLurker)
+	state.handleCancelCommand(20, baseCmdAtFrame(20, repcmd.TypeIDCancelBuild).toCmd(1))
+	state.handleCancelCommand(30, baseCmdAtFrame(30, repcmd.TypeIDCancelBuild).toCmd(1))
+
+	// The Hatchery returns 225 of its 300 minerals; the Lurker stays.
+	if state.bank.minerals != 875 || state.bank.gas != 900 {
+		t.Fatalf("expected only the Hatchery to be refunded, got %+v", state.bank)
+	}
+	if len(state.orders) != 1 || state.orders[0].unitID != unitIDLurker {
+		t.Fatalf("expected the Lurker morph to keep going, got %d orders", len(state.orders))
+	}
+}
+
+func TestReplayStateCancelBuildSkipsOlderBuildings(t *testing.T) {
+	state := newTestReplayState(repcore.RaceZerg)
+	state.bank = resourceBank{minerals: 1000}
+	state.handleBuildCommand(0, repcmd.UnitIDHatchery)
+	state.handleBuildCommand(10, repcmd.UnitIDSpawningPool)
+	state.handleCancelCommand(20, baseCmdAtFrame(20, repcmd.TypeIDCancelBuild).toCmd(1))
+
+	// The Spawning Pool returns 150 of its 200 minerals; the Hatchery keeps building.
+	if state.bank.minerals != 650 {
+		t.Fatalf("expected only the Spawning Pool to be refunded, got %+v", state.bank)
+	}
+	if len(state.orders) != 1 || state.orders[0].unitID != repcmd.UnitIDHatchery {
+		t.Fatalf("expected the Hatchery to keep building, got %d orders", len(state.orders))
+	}
+	producerEvents := 0
+	for _, event := range state.pendingEvents[1800] {
+		if event.kind == "producer" {
+			producerEvents++
+		}
+	}
+	if producerEvents != 1 {
+		t.Fatalf("expected the Hatchery to still become a producer, got %+v", state.pendingEvents[1800])
+	}
+}
+
+func TestReplayStateCancelTrainSlot(t *testing.T) {
+	tests := []struct {
+		slot repcmd.UnitTag
+		want []repcore.Frame
+	}{
+		// The cancel button takes the last SCV; the first keeps training.
+		{cancelLastSlot, []repcore.Frame{300, 0}},
+		// Canceling the SCV in training starts the next one.
+		{0, []repcore.Frame{360, 0}},
+		{1, []repcore.Frame{300, 0}},
+	}
+
+	for _, test := range tests {
+		state := newTestReplayState(repcore.RaceTerran)
+		state.availableSupplyHalf = 20
+		state.usedSupplyHalf = 8
+		producer := state.addWorkerProducer(0)
+		for i := 0; i < 3; i++ {
+			state.handleTrainCommand(0, unitIDSCV)
+		}
+		second := producer.queue[1]
+		state.handleCancelCommand(60, cancelTrainSlotAtFrame(60, test.slot).toCmd(1))
+
+		var got []repcore.Frame
+		for _, order := range producer.queue {
+			got = append(got, order.doneFrame)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("slot %d: expected done frames %v, got %v", test.slot, test.want, got)
+		}
+		if test.slot == 1 && producer.queue[1] == second {
+			t.Errorf("slot 1: expected the second SCV to be canceled")
+		}
+	}
+}
+
+func TestAnalyzeReplayFiltersIneffectiveCommands(t *testing.T) {
+	rep := terranReplayWithCommands([]timedCmd{
+		buildBuildingAtFrame(100, repcmd.UnitIDSupplyDepot),
+		buildBuildingAtFrame(105, repcmd.UnitIDSupplyDepot),
+		buildWorkerAtFrame(200),
+		baseCmdAtFrame(210, repcmd.TypeIDCancelTrain),
+	}, 60)
+	rep.Commands.Cmds[1].BaseCmd().IneffKind = repcore.IneffKindRepetition
+	rep.Commands.Cmds[3].BaseCmd().IneffKind = repcore.IneffKindFastCancel
+	player := rep.Header.Players[0]
+
+	unfiltered := analyzeMatchedReplay(rep, player, AnalysisOptions{})
+	filtered := analyzeMatchedReplay(rep, player, AnalysisOptions{FilterIneffective: true})
+
+	if len(unfiltered.BuildOrder) != 4 {
+		t.Fatalf("expected both depot orders without the filter, got %+v", unfiltered.BuildOrder)
+	}
+	// The repeated depot order is dropped; the fast cancel still happened.
+	if len(filtered.BuildOrder) != 3 || filtered.BuildOrder[2].Kind != buildOrderKindCancel {
+		t.Fatalf("expected one depot and the cancel with the filter, got %+v", filtered.BuildOrder)
+	}
+}
+
+func TestAnalyzeReplayBuildOrder(t *testing.T) {
+	rep := terranReplayWithCommands([]timedCmd{
+		buildWorkerAtFrame(0),
+		buildWorkerAtFrame(10),
+		buildWorkerAtFrame(300),
+		buildBuildingAtFrame(600, repcmd.UnitIDSupplyDepot),
+		buildBuildingAtFrame(secondFrame(7*60), repcmd.UnitIDBarracks),
+	}, 8*60)
+	result := analyzeMatchedReplay(rep, rep.Header.Players[0], AnalysisOptions{})
+
+	// The order at frame 10 is queued behind the first SCV and counts toward
+	// supply right away. The Barracks falls outside the opening window.
+	expected := []BuildOrderEntry{
+		{Second: 0, Supply: 4, Kind: buildOrderKindTrain, Action: "SCV"},
+		{Second: 0, Supply: 5, Kind: buildOrderKindTrain, Action: "SCV"},
+		{Second: 12, Supply: 6, Kind: buildOrderKindTrain, Action: "SCV"},
+		{Second: 25, Supply: 7, Kind: buildOrderKindBuild, Action: "Supply Depot"},
+	}
+	if len(result.BuildOrder) != len(expected) {
+		t.Fatalf("expected %d build order entries, got %v", len(expected), result.BuildOrder)
+	}
+	for i, entry := range expected {
+		if result.BuildOrder[i] != entry {
+			t.Fatalf("expected entry %d to be %+v, got %+v", i, entry, result.BuildOrder[i])
+		}
+	}
+}
+
+func TestAnalyzeReplayOutcome(t *testing.T) {
+	leave := func(playerID byte) *repcmd.LeaveGameCmd {
+		return &repcmd.LeaveGameCmd{Base: &repcmd.Base{PlayerID: playerID, Type: repcmd.TypeLeaveGame}}
+	}
+	tests := []struct {
+		name     string
+		computed *screp.Computed
+		want     string
+	}{
+		{"winner team", &screp.Computed{WinnerTeam: 1}, outcomeWin},
+		{"other team won", &screp.Computed{WinnerTeam: 2}, outcomeLoss},
+		// Leaves screp couldn't turn into a winner, like a disconnect, don't
+		// decide the game.
+		{"leaves without winner team", &screp.Computed{LeaveGameCmds: []*repcmd.LeaveGameCmd{leave(2), leave(1)}}, outcomeUnknown},
+		{"no result", &screp.Computed{}, outcomeUnknown},
+		{"not computed", nil, outcomeUnknown},
+	}
+
+	for _, test := range tests {
+		rep := terranReplayWithCommands(nil, 60)
+		addOpponent(rep, "bravo", repcore.RaceProtoss)
+		rep.Computed = test.computed
+
+		result := analyzeMatchedReplay(rep, rep.Header.Players[0], AnalysisOptions{})
+		if result.Outcome != test.want {
+			t.Errorf("%s: expected %q, got %q", test.name, test.want, result.Outcome)
+		}
+	}
+}
+
+func TestAggregateMacroResultsByOutcome(t *testing.T) {
+	results := []ReplayMacroResult{
+		{Matched: true, Outcome: outcomeWin, SupplyBlockedSeconds: 10, SupplyChart: chartSeriesWithValue(0, 10)},
+		{Matched: true, Outcome: outcomeLoss, SupplyBlockedSeconds: 70, SupplyChart: chartSeriesWithValue(5, 70)},
+		{Matched: true, Outcome: outcomeLoss, SupplyBlockedSeconds: 50, SupplyChart: chartSeriesWithValue(5, 50)},
+		{Matched: true, Outcome: outcomeUnknown, SupplyBlockedSeconds: 500},
+	}
+
+	summary := aggregateMacroResults(ScanTarget{DisplayLabel: "alpha"}, results, 0)
+
+	if len(summary.Outcomes) != 2 || summary.Outcomes[0].Label != "Losses" || summary.Outcomes[1].Label != "Wins" {
+		t.Fatalf("expected losses and wins, got %+v", summary.Outcomes)
+	}
+	losses := summary.Outcomes[0].Summary
+	if losses.MatchedReplays != 2 || losses.AvgSupplyBlockedSeconds != 60 || losses.SupplyChart[5] != 120 {
+		t.Fatalf("unexpected loss summary: %+v", losses)
+	}
+	if wins := summary.Outcomes[1].Summary; wins.AvgSupplyBlockedSeconds != 10 || wins.SupplyRating != "Great" {
+		t.Fatalf("unexpected win summary: %+v", wins)
+	}
+}
+
 func TestAggregateMacroResults(t *testing.T) {
 	first := ReplayMacroResult{
-		Matched:              true,
-		SupplyBlockedSeconds: 12,
-		WorkerIdleSeconds:    24,
-		SupplyChart:          chartSeriesWithValue(2, 12),
-		WorkerChart:          chartSeriesWithValue(1, 24),
+		Matched:               true,
+		SupplyBlockedSeconds:  12,
+		WorkerIdleSeconds:     24,
+		ProductionIdleSeconds: 40,
+		AvgUnspentResources:   200,
+		SupplyChart:           chartSeriesWithValue(2, 12),
+		WorkerChart:           chartSeriesWithValue(1, 24),
+		ResourceChart:         chartSeriesWithValue(3, 200),
+		ResourceChartBuckets:  chartBucketCount,
 	}
 	second := ReplayMacroResult{
-		Matched:              true,
-		SupplyBlockedSeconds: 18,
-		WorkerIdleSeconds:    36,
-		SupplyChart:          chartSeriesWithValue(2, 18),
-		WorkerChart:          chartSeriesWithValue(1, 36),
+		Matched:               true,
+		SupplyBlockedSeconds:  18,
+		WorkerIdleSeconds:     36,
+		ProductionIdleSeconds: 80,
+		AvgUnspentResources:   400,
+		SupplyChart:           chartSeriesWithValue(2, 18),
+		WorkerChart:           chartSeriesWithValue(1, 36),
+		ResourceChart:         chartSeriesWithValue(3, 400),
+		ResourceChartBuckets:  chartBucketCount,
 	}
 
 	summary := aggregateMacroResults(ScanTarget{DisplayLabel: "alpha"}, []ReplayMacroResult{first, second}, 1)
@@ -115,16 +627,84 @@ func TestAggregateMacroResults(t *testing.T) {
 	if summary.SupplyChart[2] != 30 {
 		t.Fatalf("expected aggregated supply chart bucket, got %v", summary.SupplyChart)
 	}
+	if summary.AvgProductionIdleSeconds != 60 || summary.ProductionRating != "Great" {
+		t.Fatalf("expected 60s average production idle rated Great, got %.2f %q", summary.AvgProductionIdleSeconds, summary.ProductionRating)
+	}
+	if summary.AvgUnspentResources != 300 || summary.ResourceChart[3] != 300 {
+		t.Fatalf("expected averaged unspent resources, got %.2f and %v", summary.AvgUnspentResources, summary.ResourceChart)
+	}
 	if summary.WorkerRating == "" {
 		t.Fatalf("expected worker rating to be populated")
 	}
 }
 
+func TestAggregateMacroResultsResourceChartSkipsEndedReplays(t *testing.T) {
+	long := ReplayMacroResult{Matched: true, ResourceChart: []int{100, 600}, ResourceChartBuckets: 2}
+	short := ReplayMacroResult{Matched: true, ResourceChart: []int{300, 0}, ResourceChartBuckets: 1}
+
+	summary := aggregateMacroResults(ScanTarget{DisplayLabel: "alpha"}, []ReplayMacroResult{long, short}, 1)
+
+	// The short replay ended before the second bucket and doesn't pull it down.
+	if summary.ResourceChart[0] != 200 || summary.ResourceChart[1] != 600 {
+		t.Fatalf("expected each bucket averaged over the replays still running, got %v", summary.ResourceChart[:2])
+	}
+}
+
+func TestAnalyzeReplayResourceChartBuckets(t *testing.T) {
+	rep := terranReplayWithCommands(nil, 75)
+	result := analyzeMatchedReplay(rep, rep.Header.Players[0], AnalysisOptions{})
+
+	if result.ResourceChartBuckets != 3 {
+		t.Fatalf("expected a 75s replay to sample 3 chart buckets, got %d", result.ResourceChartBuckets)
+	}
+}
+
+func TestAggregateMacroResultsMechanics(t *testing.T) {
+	results := []ReplayMacroResult{
+		{Matched: true, APM: 150, EAPM: 120, IneffectiveRatio: 0.2},
+		{Matched: true, APM: 250, EAPM: 180, IneffectiveRatio: 0.28},
+		{Matched: true},
+	}
+
+	summary := aggregateMacroResults(ScanTarget{DisplayLabel: "alpha"}, results, 0)
+
+	if summary.AvgAPM != 200 || summary.AvgEAPM != 150 {
+		t.Fatalf("expected APM averaged over replays with stats, got %.2f/%.2f", summary.AvgAPM, summary.AvgEAPM)
+	}
+	if math.Abs(summary.AvgIneffectiveRatio-0.24) > 1e-9 {
+		t.Fatalf("expected 0.24 ineffective ratio, got %.4f", summary.AvgIneffectiveRatio)
+	}
+}
+
+func TestAnalyzeReplayMechanicsFromComputedStats(t *testing.T) {
+	rep := terranReplayWithCommands([]timedCmd{buildWorker(0)}, 60)
+	player := rep.Header.Players[0]
+	rep.Computed = &screp.Computed{
+		PIDPlayerDescs: map[byte]*screp.PlayerDesc{
+			player.ID: {PlayerID: player.ID, CmdCount: 400, EffectiveCmdCount: 300, APM: 200, EAPM: 150},
+		},
+	}
+
+	result := analyzeMatchedReplay(rep, player, AnalysisOptions{})
+
+	if result.APM != 200 || result.EAPM != 150 || result.IneffectiveRatio != 0.25 {
+		t.Fatalf("unexpected mechanics stats: %d APM, %d EAPM, %.2f ineffective", result.APM, result.EAPM, result.IneffectiveRatio)
+	}
+}
+
 func terranReplayWithCommands(cmds []timedCmd, durationSeconds int) *screp.Replay {
+	return replayWithCommands(repcore.RaceTerran, cmds, durationSeconds)
+}
+
+func zergReplayWithCommands(cmds []timedCmd, durationSeconds int) *screp.Replay {
+	return replayWithCommands(repcore.RaceZerg, cmds, durationSeconds)
+}
+
+func replayWithCommands(race *repcore.Race, cmds []timedCmd, durationSeconds int) *screp.Replay {
 	player := &screp.Player{
 		ID:   1,
 		Name: "alpha",
-		Race: repcore.RaceTerran,
+		Race: race,
 		Type: repcore.PlayerTypeHuman,
 	}
 
@@ -144,22 +724,73 @@ func terranReplayWithCommands(cmds []timedCmd, durationSeconds int) *screp.Repla
 }
 
 type timedCmd struct {
-	second int
+	frame  repcore.Frame
 	kind   byte
 	unitID uint16
+	tags   []repcmd.UnitTag
+	hotkey byte
+	group  byte
+}
+
+// newTestReplayState is a replay state for race with no bases, supply or
+// money yet; tests set what they need.
+func newTestReplayState(race *repcore.Race) *replayState {
+	return &replayState{
+		config:        raceConfigs[race.ID],
+		selection:     newSelectionTracker(),
+		upgradeLevels: map[byte]int{},
+		armyProducers: map[uint16][]*productionBuilding{},
+		pendingEvents: map[repcore.Frame][]scheduledEvent{},
+	}
 }
 
 func buildWorker(second int) timedCmd {
-	return timedCmd{second: second, kind: repcmd.TypeIDTrain, unitID: unitIDSCV}
+	return buildWorkerAtFrame(secondFrame(second))
+}
+
+func buildWorkerAtFrame(frame repcore.Frame) timedCmd {
+	return timedCmd{frame: frame, kind: repcmd.TypeIDTrain, unitID: unitIDSCV}
 }
 
 func buildBuilding(second int, unitID uint16) timedCmd {
-	return timedCmd{second: second, kind: repcmd.TypeIDBuild, unitID: unitID}
+	return buildBuildingAtFrame(secondFrame(second), unitID)
+}
+
+func buildBuildingAtFrame(frame repcore.Frame, unitID uint16) timedCmd {
+	return timedCmd{frame: frame, kind: repcmd.TypeIDBuild, unitID: unitID}
+}
+
+func trainUnitAtFrame(frame repcore.Frame, unitID uint16) timedCmd {
+	return timedCmd{frame: frame, kind: repcmd.TypeIDTrain, unitID: unitID}
+}
+
+func morphUnitAtFrame(frame repcore.Frame, unitID uint16) timedCmd {
+	return timedCmd{frame: frame, kind: repcmd.TypeIDUnitMorph, unitID: unitID}
+}
+
+func selectUnitsAtFrame(frame repcore.Frame, tags ...repcmd.UnitTag) timedCmd {
+	return timedCmd{frame: frame, kind: repcmd.TypeIDSelect, tags: tags}
+}
+
+func hotkeyAtFrame(frame repcore.Frame, hotkey, group byte) timedCmd {
+	return timedCmd{frame: frame, kind: repcmd.TypeIDHotkey, hotkey: hotkey, group: group}
+}
+
+// baseCmdAtFrame is a command without parameters, like a cancel or an
+// Archon merge.
+func baseCmdAtFrame(frame repcore.Frame, typeID byte) timedCmd {
+	return timedCmd{frame: frame, kind: typeID}
+}
+
+// cancelTrainSlotAtFrame cancels the unit in a queue slot; a Cancel Train
+// from baseCmdAtFrame cancels the last one.
+func cancelTrainSlotAtFrame(frame repcore.Frame, slot repcmd.UnitTag) timedCmd {
+	return timedCmd{frame: frame, kind: repcmd.TypeIDCancelTrain, tags: []repcmd.UnitTag{slot}}
 }
 
 func (c timedCmd) toCmd(playerID byte) repcmd.Cmd {
 	base := &repcmd.Base{
-		Frame:    secondFrame(c.second),
+		Frame:    c.frame,
 		PlayerID: playerID,
 	}
 
@@ -170,6 +801,39 @@ func (c timedCmd) toCmd(playerID byte) repcmd.Cmd {
 			Base: base,
 			Unit: repcmd.UnitByID(c.unitID),
 		}
+	case repcmd.TypeIDSelect, repcmd.TypeIDSelectAdd, repcmd.TypeIDSelectRemove:
+		base.Type = repcmd.TypeByID(c.kind)
+		return &repcmd.SelectCmd{
+			Base:     base,
+			UnitTags: c.tags,
+		}
+	case repcmd.TypeIDHotkey:
+		base.Type = repcmd.TypeHotkey
+		return &repcmd.HotkeyCmd{
+			Base:       base,
+			HotkeyType: repcmd.HotkeyTypeByID(c.hotkey),
+			Group:      c.group,
+		}
+	case repcmd.TypeIDCancelTrain:
+		base.Type = repcmd.TypeCancelTrain
+		slot := cancelLastSlot
+		if len(c.tags) > 0 {
+			slot = c.tags[0]
+		}
+		return &repcmd.CancelTrainCmd{
+			Base:    base,
+			UnitTag: slot,
+		}
+	case repcmd.TypeIDCancelBuild, repcmd.TypeIDCancelMorph, repcmd.TypeIDCancelUpgrade, repcmd.TypeIDCancelTech, repcmd.TypeIDCancelAddon,
+		repcmd.TypeIDMergeArchon, repcmd.TypeIDMergeDarkArchon:
+		base.Type = repcmd.TypeByID(c.kind)
+		return base
+	case repcmd.TypeIDUnitMorph:
+		base.Type = repcmd.TypeUnitMorph
+		return &repcmd.TrainCmd{
+			Base: base,
+			Unit: repcmd.UnitByID(c.unitID),
+		}
 	default:
 		base.Type = repcmd.TypeTrain
 		return &repcmd.TrainCmd{
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"github.com/icza/screp/rep/repcmd"
+	"github.com/icza/screp/rep/repcore"
+)
+
+// buildOrderWindowSeconds limits build order extraction to the opening.
+const buildOrderWindowSeconds = 6 * 60
+
+// Build order action kinds, one per command type that places an order.
+const (
+	buildOrderKindBuild   = "Build"
+	buildOrderKindTrain   = "Train"
+	buildOrderKindMorph   = "Morph"
+	buildOrderKindUpgrade = "Upgrade"
+	buildOrderKindTech    = "Tech"
+)
+
+// estimatedSupply is the supply count in the usual build order notation:
+// finished units plus units in production.
+func (s *replayState) estimatedSupply() int {
+	return (s.usedSupplyHalf + s.queuedSupplyHalf) / 2
+}
+
+// recordBuildOrder appends an accepted order to the build order while the
+// replay is still within the opening window.
+func (s *replayState) recordBuildOrder(frame repcore.Frame, kind, action string) {
+	if frame.Duration() >= seconds(buildOrderWindowSeconds) {
+		return
+	}
+	s.buildOrder = append(s.buildOrder, BuildOrderEntry{
+		Second: int(frame.Seconds()),
+		Supply: s.estimatedSupply(),
+		Kind:   kind,
+		Action: action,
+	})
+}
+
+func unitName(unitID uint16) string {
+	return repcmd.UnitByID(unitID).Name
+}
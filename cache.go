@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// analyzerVersion stamps the replay cache. Bump it whenever a change to the
+// simulation or to ReplayMacroResult would change cached results; a cache
+// written by another version is thrown away on load.
+const analyzerVersion = 8
+
+// replayAnalysis is what scanning one replay file produced for one target.
+// Result.Matched is false when the target isn't in the replay. The cache
+// keeps analyses from before the scan filter ran, so Filtered is never
+// stored; NeedsParse only marks replays between the two scan passes.
+type replayAnalysis struct {
+	Skipped        bool
+	Filtered       bool `json:"-"`
+	NeedsParse     bool `json:"-"`
+	TargetObserved bool
+	ActivePlayers  int
+	Result         ReplayMacroResult
+}
+
+// replayCacheEntry remembers the analyses of one replay file. Size and
+// ModTime find unchanged files without reading them; Hash recognizes a file
+// that was touched or copied but has the same content.
+type replayCacheEntry struct {
+	Size     int64
+	ModTime  time.Time
+	Hash     string
+	Analyses map[string]replayAnalysis
+}
+
+// replayCache is an on-disk cache of replay analyses keyed by replay path.
+// A nil cache caches nothing.
+type replayCache struct {
+	mu      sync.Mutex
+	path    string
+	Version int
+	Entries map[string]*replayCacheEntry
+}
+
+// defaultReplayCachePath is where the replay cache lives for the current
+// user, or "" when there is no cache directory.
+func defaultReplayCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "bwstats", "replay-cache.json")
+}
+
+// loadReplayCache reads the cache at path. A missing, unreadable or outdated
+// cache starts out empty; an empty path disables caching.
+func loadReplayCache(path string) *replayCache {
+	if path == "" {
+		return nil
+	}
+	cache := &replayCache{path: path, Version: analyzerVersion, Entries: map[string]*replayCacheEntry{}}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return cache
+	}
+	var stored replayCache
+	if err := json.Unmarshal(b, &stored); err != nil || stored.Version != analyzerVersion || stored.Entries == nil {
+		return cache
+	}
+	cache.Entries = stored.Entries
+	return cache
+}
+
+// save writes the cache back to disk, leaving out replays that no longer
+// exist.
+func (c *replayCache) save() error {
+	if c == nil {
+		return nil
+	}
+	for path := range c.Entries {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			delete(c.Entries, path)
+		}
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to encode replay cache: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %v", err)
+	}
+	// Write to a temporary file first so an interrupted save can't leave a
+	// truncated cache behind.
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("failed to write replay cache: %v", err)
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// lookup returns the cached analysis of the replay at path for key, if the
+// file is unchanged since it was cached.
+func (c *replayCache) lookup(path string, info os.FileInfo, key string) (replayAnalysis, bool) {
+	if c == nil {
+		return replayAnalysis{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.Entries[path]
+	if entry == nil || entry.Size != info.Size() {
+		return replayAnalysis{}, false
+	}
+	analysis, ok := entry.Analyses[key]
+	if !ok {
+		return replayAnalysis{}, false
+	}
+	if !entry.ModTime.Equal(info.ModTime()) {
+		hash, err := hashFile(path)
+		if err != nil || hash != entry.Hash {
+			return replayAnalysis{}, false
+		}
+		entry.ModTime = info.ModTime()
+	}
+	return analysis, true
+}
+
+// store caches the analysis of the replay at path for key. Analyses for
+// other keys survive as long as the file is unchanged.
+func (c *replayCache) store(path string, info os.FileInfo, key string, analysis replayAnalysis) {
+	if c == nil {
+		return
+	}
+	hash, err := hashFile(path)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.Entries[path]
+	if entry == nil || entry.Size != info.Size() || entry.Hash != hash {
+		entry = &replayCacheEntry{Size: info.Size(), Hash: hash, Analyses: map[string]replayAnalysis{}}
+		c.Entries[path] = entry
+	}
+	entry.ModTime = info.ModTime()
+	entry.Analyses[key] = analysis
+}
+
+// cacheKey identifies the target settings an analysis depends on: the
+// player names and the analysis options.
+func (t ScanTarget) cacheKey() string {
+	names := append([]string(nil), t.Names...)
+	sort.Strings(names)
+	return fmt.Sprintf("%s|%+v", strings.Join(names, "\x00"), t.Analysis)
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReplayCacheRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	repFile := filepath.Join(dir, "game.rep")
+	writeReplayBytes(t, repFile, "replay one", time.Date(2026, 1, 1, 20, 0, 0, 0, time.UTC))
+	cachePath := filepath.Join(dir, "cache", "replay-cache.json")
+	key := ScanTarget{Names: []string{"bravo", "alpha"}}.cacheKey()
+
+	cache := loadReplayCache(cachePath)
+	cache.store(repFile, statFile(t, repFile), key, replayAnalysis{Result: ReplayMacroResult{Matched: true, SupplyBlockedSeconds: 42, Opponents: []string{"charlie"}}})
+	if err := cache.save(); err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+
+	reloaded := loadReplayCache(cachePath)
+	analysis, ok := reloaded.lookup(repFile, statFile(t, repFile), ScanTarget{Names: []string{"alpha", "bravo"}}.cacheKey())
+	if !ok || !analysis.Result.Matched || analysis.Result.SupplyBlockedSeconds != 42 || analysis.Result.Opponents[0] != "charlie" {
+		t.Fatalf("expected the cached analysis back, got %+v (hit %v)", analysis, ok)
+	}
+	if _, ok := reloaded.lookup(repFile, statFile(t, repFile), ScanTarget{Names: []string{"alpha"}}.cacheKey()); ok {
+		t.Fatalf("expected another target to miss the cache")
+	}
+}
+
+func TestReplayCacheDetectsChanges(t *testing.T) {
+	dir := t.TempDir()
+	repFile := filepath.Join(dir, "game.rep")
+	writeReplayBytes(t, repFile, "replay one", time.Date(2026, 1, 1, 20, 0, 0, 0, time.UTC))
+	key := ScanTarget{Names: []string{"alpha"}}.cacheKey()
+	cache := loadReplayCache(filepath.Join(dir, "replay-cache.json"))
+	cache.store(repFile, statFile(t, repFile), key, replayAnalysis{Skipped: true})
+
+	// Touched but unchanged: the content hash still matches.
+	writeReplayBytes(t, repFile, "replay one", time.Date(2026, 2, 1, 20, 0, 0, 0, time.UTC))
+	if analysis, ok := cache.lookup(repFile, statFile(t, repFile), key); !ok || !analysis.Skipped {
+		t.Fatalf("expected a touched replay to stay cached, got %+v (hit %v)", analysis, ok)
+	}
+
+	// Same size, new content.
+	writeReplayBytes(t, repFile, "replay two", time.Date(2026, 3, 1, 20, 0, 0, 0, time.UTC))
+	if _, ok := cache.lookup(repFile, statFile(t, repFile), key); ok {
+		t.Fatalf("expected a changed replay to miss the cache")
+	}
+}
+
+func TestReplayCacheVersionStamp(t *testing.T) {
+	dir := t.TempDir()
+	cachePath := filepath.Join(dir, "replay-cache.json")
+	stale := `{"Version":0,"Entries":{"game.rep":{"Size":1,"Analyses":{}}}}`
+	if err := os.WriteFile(cachePath, []byte(stale), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if cache := loadReplayCache(cachePath); len(cache.Entries) != 0 || cache.Version != analyzerVersion {
+		t.Fatalf("expected a cache from another analyzer version to be dropped, got %+v", cache)
+	}
+	if cache := loadReplayCache(""); cache != nil {
+		t.Fatalf("expected no cache without a path")
+	}
+}
+
+func writeReplayBytes(t *testing.T, path, content string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func statFile(t *testing.T, path string) os.FileInfo {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
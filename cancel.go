@@ -0,0 +1,209 @@
+package main
+
+import (
+	"github.com/icza/screp/rep/repcmd"
+	"github.com/icza/screp/rep/repcore"
+)
+
+// Order kinds a cancel command can undo.
+const (
+	orderKindTrain    = "train"
+	orderKindBuild    = "build"
+	orderKindMorph    = "morph"
+	orderKindResearch = "research"
+)
+
+// buildingCancelRefund is the share of its cost a building returns when it
+// is canceled under construction. Units, morphs and research are refunded in
+// full.
+const buildingCancelRefund = 0.75
+
+const buildOrderKindCancel = "Cancel"
+
+// cancelLastSlot is the Cancel Train slot the cancel button sends: the last
+// unit in the queue. Clicking a unit in the queue sends its slot instead.
+const cancelLastSlot repcmd.UnitTag = 254
+
+// pendingOrder is an accepted order that has not finished yet. Its scheduled
+// events carry its id so a cancel can take them back out. Units waiting in a
+// production queue have no doneFrame until they start.
+type pendingOrder struct {
+	id          int
+	kind        string
+	unitID      uint16
+	buildFrames repcore.Frame
+	doneFrame   repcore.Frame
+	started     bool
+	producer    *productionBuilding
+	larva       *productionBuilding
+	cost        resourceCost
+	supplyHalf  int
+	usedDrone   bool
+	upgradeID   byte
+	upgrade     bool
+	name        string
+}
+
+// addonUnitIDs are the Terran add-ons, canceled with Cancel Addon instead of
+// Cancel Build.
+var addonUnitIDs = map[uint16]bool{
+	repcmd.UnitIDComSat:       true,
+	repcmd.UnitIDNuclearSilo:  true,
+	repcmd.UnitIDControlTower: true,
+	repcmd.UnitIDCovertOps:    true,
+	repcmd.UnitIDPhysicsLab:   true,
+	repcmd.UnitIDMachineShop:  true,
+}
+
+// startOrder remembers an accepted order. Its scheduled events should carry
+// the returned order's id.
+func (s *replayState) startOrder(order pendingOrder) *pendingOrder {
+	s.nextOrderID++
+	order.id = s.nextOrderID
+	s.orders = append(s.orders, &order)
+	return &order
+}
+
+// handleCancelCommand undoes the newest pending order the cancel applies to.
+// Cancel Train goes to the selected building's queue slot; the other cancels
+// can't be tied to a unit, so they take the most recent matching order.
+func (s *replayState) handleCancelCommand(frame repcore.Frame, cmd repcmd.Cmd) {
+	s.pruneOrders(frame)
+
+	var order *pendingOrder
+	switch cmd.BaseCmd().Type.ID {
+	case repcmd.TypeIDCancelTrain:
+		slot := cancelLastSlot
+		if cancel, ok := cmd.(*repcmd.CancelTrainCmd); ok {
+			slot = cancel.UnitTag
+		}
+		order = s.findTrainOrder(slot)
+	case repcmd.TypeIDCancelBuild:
+		order = s.findOrder(func(order *pendingOrder) bool {
+			return order.kind == orderKindBuild && !addonUnitIDs[order.unitID]
+		})
+	case repcmd.TypeIDCancelAddon:
+		order = s.findOrder(func(order *pendingOrder) bool {
+			return order.kind == orderKindBuild && addonUnitIDs[order.unitID]
+		})
+	case repcmd.TypeIDCancelMorph:
+		order = s.findOrder(func(order *pendingOrder) bool {
+			return order.kind == orderKindMorph || order.kind == orderKindTrain && order.larva != nil
+		})
+	case repcmd.TypeIDCancelUpgrade:
+		order = s.findOrder(func(order *pendingOrder) bool {
+			return order.kind == orderKindResearch && order.upgrade
+		})
+	case repcmd.TypeIDCancelTech:
+		order = s.findOrder(func(order *pendingOrder) bool {
+			return order.kind == orderKindResearch && !order.upgrade
+		})
+	}
+	if order != nil {
+		s.cancelOrder(frame, order)
+	}
+}
+
+// findTrainOrder picks the unit in slot of the selected building's queue,
+// or of the building with the newest train order without selection data.
+// The last slot, or a slot past the end of the queue, takes the last unit.
+func (s *replayState) findTrainOrder(slot repcmd.UnitTag) *pendingOrder {
+	var producer *productionBuilding
+	if tag, selected := s.selection.producer(); selected {
+		order := s.findOrder(func(order *pendingOrder) bool {
+			return order.kind == orderKindTrain && order.producer != nil &&
+				order.producer.bound && order.producer.tag == tag
+		})
+		if order != nil {
+			producer = order.producer
+		}
+	}
+	if producer == nil {
+		order := s.findOrder(func(order *pendingOrder) bool {
+			return order.kind == orderKindTrain && order.producer != nil
+		})
+		if order == nil {
+			return nil
+		}
+		producer = order.producer
+	}
+
+	if len(producer.queue) == 0 {
+		return nil
+	}
+	if int(slot) < len(producer.queue) {
+		return producer.queue[slot]
+	}
+	return producer.queue[len(producer.queue)-1]
+}
+
+func (s *replayState) findOrder(match func(*pendingOrder) bool) *pendingOrder {
+	for i := len(s.orders) - 1; i >= 0; i-- {
+		if match(s.orders[i]) {
+			return s.orders[i]
+		}
+	}
+	return nil
+}
+
+// pruneOrders forgets orders that have finished by frame. Queued units that
+// haven't started are kept.
+func (s *replayState) pruneOrders(frame repcore.Frame) {
+	kept := s.orders[:0]
+	for _, order := range s.orders {
+		if !order.started && order.kind == orderKindTrain || order.doneFrame > frame {
+			kept = append(kept, order)
+		}
+	}
+	s.orders = kept
+}
+
+// cancelOrder removes the order's scheduled events and reverses what it
+// changed when it started: queued supply, busy producers, used larvae and
+// drones, upgrade levels and the money spent.
+func (s *replayState) cancelOrder(frame repcore.Frame, order *pendingOrder) {
+	events := s.pendingEvents[order.doneFrame]
+	kept := events[:0]
+	for _, event := range events {
+		if event.order != order.id {
+			kept = append(kept, event)
+		}
+	}
+	s.pendingEvents[order.doneFrame] = kept
+	if s.isSupplyBlocked(frame) && s.supplyBlockedUntil == order.doneFrame {
+		s.supplyBlockedUntil = s.nextSupplyReliefFrame(frame)
+	}
+
+	s.queuedSupplyHalf -= order.supplyHalf
+	if order.producer != nil {
+		order.producer.removeFromQueue(order)
+		if order.started && order.producer.busy() {
+			s.startTraining(frame, order.producer.queue[0])
+		}
+	}
+	if order.larva != nil && order.larva.larva < maxLarvaPerHatchery {
+		order.larva.larva++
+	}
+	if order.usedDrone {
+		s.usedSupplyHalf += 2
+		s.workerCount++
+	}
+	if order.upgrade {
+		s.upgradeLevels[order.upgradeID]--
+	}
+
+	refund := order.cost
+	if order.kind == orderKindBuild {
+		refund.minerals = int(float64(refund.minerals) * buildingCancelRefund)
+		refund.gas = int(float64(refund.gas) * buildingCancelRefund)
+	}
+	s.bank.refund(refund)
+	s.recordBuildOrder(frame, buildOrderKindCancel, order.name)
+
+	for i, pending := range s.orders {
+		if pending == order {
+			s.orders = append(s.orders[:i], s.orders[i+1:]...)
+			break
+		}
+	}
+}
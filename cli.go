@@ -0,0 +1,49 @@
+//go:build !windows && !darwin && !gui
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+)
+
+// main scans from the command line on platforms where the Fyne window isn't
+// built by default; build with -tags gui for the window. Interrupting the
+// scan prints the partial results.
+func main() {
+	player := flag.String("player", "", "player name to scan for instead of the CSettings.json accounts")
+	var roots []string
+	flag.Func("root", "extra replay folder, as `folder | include | !exclude`; repeatable", func(root string) error {
+		roots = append(roots, root)
+		return nil
+	})
+	flag.Parse()
+
+	identity, err := loadPlayerIdentity()
+	if err != nil && *player == "" {
+		fmt.Fprintln(os.Stderr, "Error loading settings:", err)
+		os.Exit(1)
+	}
+
+	target := resolveScanTarget(identity, *player)
+	target.Roots = parseReplayRoots(strings.Join(roots, "\n"))
+	target.CachePath = defaultReplayCachePath()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	summary, err := scanMacroStats(ctx, target, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	for _, line := range formatSummaryLines(summary) {
+		fmt.Println(line)
+	}
+	if summary.Incomplete {
+		fmt.Println("Scan canceled; showing partial results.")
+	}
+}
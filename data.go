@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io/fs"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 )
@@ -21,63 +24,147 @@ type Settings struct {
 }
 
 // findReplayFilesInDir scans a provided replay directory and returns a list of all .rep files
-func findReplayFilesInDir(replayDir string, progressCallback func(float64)) ([]string, error) {
-	// Check if the replay directory exists
-	if _, err := os.Stat(replayDir); os.IsNotExist(err) {
-		return nil, fmt.Errorf("autoreplays directory not found: %s", replayDir)
-	}
-
-	// Get subdirectories (date folders)
-	subdirs, err := os.ReadDir(replayDir)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read autoreplays directory: %v", err)
-	}
-
-	if len(subdirs) == 0 {
-		return nil, fmt.Errorf("no date folders found in autoreplays directory")
-	}
+func findReplayFilesInDir(ctx context.Context, replayDir string, progressCallback func(float64)) ([]string, error) {
+	return findReplayFilesInRoots(ctx, []ReplayRoot{{Path: replayDir}}, progressCallback)
+}
 
-	// Find all .rep files in the directory and subdirectories
+// findReplayFilesInRoots walks every root at any depth and returns the .rep
+// files its patterns let through. A file reachable from overlapping roots is
+// listed once, under the first root that finds it. The walk stops with
+// ctx's error once ctx is done.
+func findReplayFilesInRoots(ctx context.Context, roots []ReplayRoot, progressCallback func(float64)) ([]string, error) {
 	var repFiles []string
+	seen := map[string]bool{}
 
-	// Walk through each subdirectory to find all .rep files
-	for i, subdir := range subdirs {
-		if !subdir.IsDir() {
-			continue
+	for i, root := range roots {
+		// Check if the replay directory exists
+		if _, err := os.Stat(root.Path); os.IsNotExist(err) {
+			return nil, fmt.Errorf("replay directory not found: %s", root.Path)
 		}
 
-		subDirPath := filepath.Join(replayDir, subdir.Name())
-		err := filepath.Walk(subDirPath, func(path string, info os.FileInfo, err error) error {
+		entries, err := os.ReadDir(root.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read replay directory: %v", err)
+		}
+
+		// Walk each top-level entry so progress moves while a large root is scanned
+		for j, entry := range entries {
+			entryPath := filepath.Join(root.Path, entry.Name())
+			err := filepath.WalkDir(entryPath, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+
+				rel, err := filepath.Rel(root.Path, path)
+				if err != nil {
+					return err
+				}
+				if d.IsDir() {
+					if root.excludes(rel) {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+
+				// Check if it's a .rep file the root's patterns allow
+				if !strings.HasSuffix(strings.ToLower(d.Name()), ".rep") || !root.allows(rel) {
+					return nil
+				}
+				key := path
+				if abs, err := filepath.Abs(path); err == nil {
+					key = abs
+				}
+				if !seen[key] {
+					seen[key] = true
+					repFiles = append(repFiles, path)
+				}
+				return nil
+			})
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
 			if err != nil {
-				return err
+				return nil, fmt.Errorf("failed to walk %s: %v", entryPath, err)
 			}
 
-			// Check if it's a .rep file
-			if !info.IsDir() && strings.HasSuffix(strings.ToLower(info.Name()), ".rep") {
-				repFiles = append(repFiles, path)
+			if progressCallback != nil {
+				progressCallback((float64(i) + float64(j+1)/float64(len(entries))) / float64(len(roots)))
 			}
+		}
 
-			return nil
-		})
+		if progressCallback != nil && len(entries) == 0 {
+			progressCallback(float64(i+1) / float64(len(roots)))
+		}
+	}
 
-		// Update progress after each subdirectory is processed
-		if progressCallback != nil {
-			progressCallback(float64(i+1) / float64(len(subdirs)))
+	return repFiles, nil
+}
+
+// allows reports whether a file at rel, relative to the root, is scanned:
+// it must match an include pattern when there are any, and no exclude.
+func (r ReplayRoot) allows(rel string) bool {
+	if r.excludes(rel) {
+		return false
+	}
+	if len(r.Include) == 0 {
+		return true
+	}
+	for _, pattern := range r.Include {
+		if matchReplayPattern(pattern, rel) {
+			return true
 		}
+	}
+	return false
+}
 
-		if err != nil {
-			return nil, fmt.Errorf("failed to walk subdirectory %s: %v", subdir.Name(), err)
+func (r ReplayRoot) excludes(rel string) bool {
+	for _, pattern := range r.Exclude {
+		if matchReplayPattern(pattern, rel) {
+			return true
 		}
 	}
+	return false
+}
 
-	return repFiles, nil
+// matchReplayPattern matches a glob against a path relative to its root. A
+// pattern with a slash matches the path or one of its leading directories
+// ("pro/*" matches "pro/2024/game.rep"); a pattern without one matches any
+// single element ("*.rep", "old").
+func matchReplayPattern(pattern, rel string) bool {
+	pattern = filepath.ToSlash(pattern)
+	elements := strings.Split(filepath.ToSlash(rel), "/")
+	withSlash := strings.Contains(pattern, "/")
+	for i := range elements {
+		name := elements[i]
+		if withSlash {
+			name = strings.Join(elements[:i+1], "/")
+		}
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
 }
 
-// findReplayFiles scans the user's replay directory and returns a list of all .rep files
-func findReplayFiles(progressCallback func(float64)) ([]string, error) {
-	// Get the replay directory path
-	replayDir := filepath.Join(os.Getenv("USERPROFILE"), "Documents", "StarCraft", "Maps", "Replays", "AutoSave")
-	return findReplayFilesInDir(replayDir, progressCallback)
+// findReplayFiles scans the discovered AutoSave folder and the extra roots
+// and returns a list of all .rep files. Without extra roots a missing
+// AutoSave folder is an error; with them it is skipped.
+func findReplayFiles(ctx context.Context, extraRoots []ReplayRoot, progressCallback func(float64)) ([]string, error) {
+	env := currentDiscoveryEnv()
+	discovery := discoverStarCraftPaths(env)
+
+	var roots []ReplayRoot
+	if len(discovery.ReplayRoots) > 0 {
+		roots = append(roots, ReplayRoot{Path: discovery.ReplayRoots[0].Path})
+	} else if len(extraRoots) == 0 {
+		// Nothing found; report the default location as missing.
+		roots = append(roots, ReplayRoot{Path: filepath.Join(defaultStarCraftDocumentsDir(env), "Maps", "Replays", "AutoSave")})
+	}
+	roots = append(roots, extraRoots...)
+	return findReplayFilesInRoots(ctx, roots, progressCallback)
 }
 
 // loadPlayerIdentityFromPath loads the current player aliases from a specific
@@ -132,15 +219,17 @@ func loadPlayerIdentityFromPath(settingsPath string) (PlayerIdentity, error) {
 	}, nil
 }
 
-// loadPlayerIdentity loads the current player aliases from CSettings.json.
+// loadPlayerIdentity loads the current player aliases from the first
+// CSettings.json discovery finds.
 func loadPlayerIdentity() (PlayerIdentity, error) {
-	// Get user home directory
-	userHome, err := os.UserHomeDir()
-	if err != nil {
-		return PlayerIdentity{}, fmt.Errorf("failed to get user home directory: %v", err)
+	env := currentDiscoveryEnv()
+	if env.home == "" {
+		return PlayerIdentity{}, fmt.Errorf("failed to get user home directory")
 	}
 
-	// Construct path to CSettings.json similarly to akafinder
-	settingsPath := filepath.Join(userHome, "Documents", "StarCraft", "CSettings.json")
-	return loadPlayerIdentityFromPath(settingsPath)
+	discovery := discoverStarCraftPaths(env)
+	if len(discovery.SettingsPaths) > 0 {
+		return loadPlayerIdentityFromPath(discovery.SettingsPaths[0].Path)
+	}
+	return loadPlayerIdentityFromPath(filepath.Join(defaultStarCraftDocumentsDir(env), "CSettings.json"))
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"testing"
@@ -21,7 +22,7 @@ func TestFindReplayFilesInDir(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	repFiles, err := findReplayFilesInDir(tempDir, nil)
+	repFiles, err := findReplayFilesInDir(context.Background(), tempDir, nil)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -30,6 +31,87 @@ func TestFindReplayFilesInDir(t *testing.T) {
 	}
 }
 
+func TestFindReplayFilesInDirTopLevelAndNested(t *testing.T) {
+	tempDir := t.TempDir()
+	createReplayFiles(t, tempDir, "saved.rep", "2026-01-01/game1.rep", "pro/2025/finals/game2.REP", "pro/readme.txt")
+
+	repFiles, err := findReplayFilesInDir(context.Background(), tempDir, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(repFiles) != 3 {
+		t.Fatalf("expected 3 .rep files at any depth, got %v", repFiles)
+	}
+}
+
+func TestFindReplayFilesInDirWithoutDateFolders(t *testing.T) {
+	repFiles, err := findReplayFilesInDir(context.Background(), t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("expected an empty folder to scan cleanly, got %v", err)
+	}
+	if len(repFiles) != 0 {
+		t.Fatalf("expected no replays, got %v", repFiles)
+	}
+}
+
+func TestFindReplayFilesInRootsOverlapAndPatterns(t *testing.T) {
+	tempDir := t.TempDir()
+	createReplayFiles(t, tempDir,
+		"AutoSave/2026-01-01/game1.rep",
+		"Saved/best.rep",
+		"Saved/old/2019.rep",
+		"Saved/ums/tower.rep",
+	)
+	roots := []ReplayRoot{
+		{Path: filepath.Join(tempDir, "AutoSave")},
+		{Path: tempDir, Exclude: []string{"old", "Saved/ums/*"}},
+	}
+
+	var progress []float64
+	repFiles, err := findReplayFilesInRoots(context.Background(), roots, func(p float64) { progress = append(progress, p) })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{
+		filepath.Join(tempDir, "AutoSave", "2026-01-01", "game1.rep"),
+		filepath.Join(tempDir, "Saved", "best.rep"),
+	}
+	if len(repFiles) != len(want) || repFiles[0] != want[0] || repFiles[1] != want[1] {
+		t.Fatalf("expected %v, got %v", want, repFiles)
+	}
+	if len(progress) == 0 || progress[len(progress)-1] != 1 {
+		t.Fatalf("expected progress to end at 1, got %v", progress)
+	}
+
+	included, err := findReplayFilesInRoots(context.Background(), []ReplayRoot{{Path: tempDir, Include: []string{"Saved/*"}, Exclude: []string{"old"}}}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(included) != 2 {
+		t.Fatalf("expected the two non-old replays under Saved, got %v", included)
+	}
+}
+
+func TestMatchReplayPattern(t *testing.T) {
+	tests := []struct {
+		pattern, rel string
+		want         bool
+	}{
+		{"*.rep", "2026-01-01/game.rep", true},
+		{"old", "old/2019/game.rep", true},
+		{"old", "gold/game.rep", false},
+		{"pro/*", "pro/2025/game.rep", true},
+		{"pro/*", "archive/pro/game.rep", false},
+		{"2026-*", "2026-01-01/game.rep", true},
+	}
+
+	for _, test := range tests {
+		if got := matchReplayPattern(test.pattern, test.rel); got != test.want {
+			t.Errorf("matchReplayPattern(%q, %q) = %v, want %v", test.pattern, test.rel, got, test.want)
+		}
+	}
+}
+
 func TestLoadPlayerIdentityFromPath(t *testing.T) {
 	tempDir := t.TempDir()
 	settingsPath := filepath.Join(tempDir, "CSettings.json")
@@ -61,3 +143,16 @@ func TestResolveScanTargetManualOverride(t *testing.T) {
 		t.Fatalf("expected manual override target, got %#v", target.Names)
 	}
 }
+
+func createReplayFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
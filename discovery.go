@@ -0,0 +1,153 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+)
+
+// DiscoveredPath is a replay folder or settings file found on disk, with the
+// reason it was picked.
+type DiscoveredPath struct {
+	Path   string
+	Reason string
+}
+
+// Discovery lists the replay roots and CSettings.json files found, best
+// candidate first.
+type Discovery struct {
+	ReplayRoots   []DiscoveredPath
+	SettingsPaths []DiscoveredPath
+}
+
+// discoveryEnv is everything discovery looks at besides the file system, so
+// tests can point it at a fake directory tree.
+type discoveryEnv struct {
+	goos   string
+	home   string
+	getenv func(string) string
+}
+
+func currentDiscoveryEnv() discoveryEnv {
+	home, _ := os.UserHomeDir()
+	return discoveryEnv{goos: runtime.GOOS, home: home, getenv: os.Getenv}
+}
+
+// discoverStarCraftPaths looks for StarCraft Documents folders in the places
+// the game keeps them on the current platform and returns the AutoSave
+// replay roots and settings files that exist.
+func discoverStarCraftPaths(env discoveryEnv) Discovery {
+	var discovery Discovery
+	seenRoots := map[string]bool{}
+	seenSettings := map[string]bool{}
+	for _, candidate := range starCraftDocumentsCandidates(env) {
+		root := filepath.Join(candidate.Path, "Maps", "Replays", "AutoSave")
+		if isDir(root) && !seenRoots[root] {
+			seenRoots[root] = true
+			discovery.ReplayRoots = append(discovery.ReplayRoots, DiscoveredPath{Path: root, Reason: candidate.Reason})
+		}
+		settings := filepath.Join(candidate.Path, "CSettings.json")
+		if isFile(settings) && !seenSettings[settings] {
+			seenSettings[settings] = true
+			discovery.SettingsPaths = append(discovery.SettingsPaths, DiscoveredPath{Path: settings, Reason: candidate.Reason})
+		}
+	}
+	return discovery
+}
+
+// defaultStarCraftDocumentsDir is where the game keeps its Documents folder
+// on a plain install, used when discovery finds nothing.
+func defaultStarCraftDocumentsDir(env discoveryEnv) string {
+	if env.goos == "windows" {
+		if profile := env.getenv("USERPROFILE"); profile != "" {
+			return filepath.Join(profile, "Documents", "StarCraft")
+		}
+	}
+	return filepath.Join(env.home, "Documents", "StarCraft")
+}
+
+// starCraftDocumentsCandidates lists the possible StarCraft Documents
+// folders, whether they exist or not.
+func starCraftDocumentsCandidates(env discoveryEnv) []DiscoveredPath {
+	var candidates []DiscoveredPath
+	add := func(dir, reason string) {
+		if dir != "" {
+			candidates = append(candidates, DiscoveredPath{Path: dir, Reason: reason})
+		}
+	}
+
+	switch env.goos {
+	case "windows":
+		if profile := env.getenv("USERPROFILE"); profile != "" {
+			add(filepath.Join(profile, "Documents", "StarCraft"), "Windows Documents folder (USERPROFILE)")
+		}
+		if oneDrive := env.getenv("OneDrive"); oneDrive != "" {
+			add(filepath.Join(oneDrive, "Documents", "StarCraft"), "Documents folder redirected to OneDrive")
+		}
+		add(filepath.Join(env.home, "Documents", "StarCraft"), "Documents folder in the home directory")
+	case "darwin":
+		add(filepath.Join(env.home, "Library", "Application Support", "Blizzard", "StarCraft"), "macOS Application Support folder")
+		add(filepath.Join(env.home, "Documents", "StarCraft"), "macOS Documents folder")
+	default:
+		if prefix := env.getenv("WINEPREFIX"); prefix != "" {
+			candidates = append(candidates, wineDocumentsCandidates(prefix, "Wine prefix from WINEPREFIX")...)
+		}
+		candidates = append(candidates, wineDocumentsCandidates(filepath.Join(env.home, ".wine"), "default Wine prefix")...)
+		for _, prefix := range globDirs(filepath.Join(env.home, "Games", "*")) {
+			candidates = append(candidates, wineDocumentsCandidates(prefix, "Lutris Wine prefix "+filepath.Base(prefix))...)
+		}
+		for _, steam := range []string{
+			filepath.Join(env.home, ".steam", "steam"),
+			filepath.Join(env.home, ".local", "share", "Steam"),
+		} {
+			for _, compat := range globDirs(filepath.Join(steam, "steamapps", "compatdata", "*")) {
+				candidates = append(candidates, wineDocumentsCandidates(filepath.Join(compat, "pfx"), "Proton prefix for Steam app "+filepath.Base(compat))...)
+			}
+		}
+		add(filepath.Join(env.home, "Documents", "StarCraft"), "Documents folder in the home directory")
+	}
+	return candidates
+}
+
+// wineDocumentsCandidates lists the StarCraft Documents folders of every
+// user in a Wine prefix. Older Wine versions name the folder "My Documents".
+func wineDocumentsCandidates(prefix, reason string) []DiscoveredPath {
+	var candidates []DiscoveredPath
+	for _, user := range globDirs(filepath.Join(prefix, "drive_c", "users", "*")) {
+		name := filepath.Base(user)
+		if name == "Public" {
+			continue
+		}
+		for _, documents := range []string{"Documents", "My Documents"} {
+			candidates = append(candidates, DiscoveredPath{
+				Path:   filepath.Join(user, documents, "StarCraft"),
+				Reason: reason + ", user " + name,
+			})
+		}
+	}
+	return candidates
+}
+
+// globDirs returns the directories matching pattern in sorted order.
+func globDirs(pattern string) []string {
+	matches, _ := filepath.Glob(pattern)
+	var dirs []string
+	for _, match := range matches {
+		if isDir(match) {
+			dirs = append(dirs, match)
+		}
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
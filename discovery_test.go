@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiscoverStarCraftPathsWindows(t *testing.T) {
+	root := t.TempDir()
+	profile := filepath.Join(root, "Users", "alpha")
+	oneDrive := filepath.Join(profile, "OneDrive")
+	makeStarCraftDocuments(t, filepath.Join(oneDrive, "Documents", "StarCraft"), true, true)
+	makeStarCraftDocuments(t, filepath.Join(profile, "Documents", "StarCraft"), false, true)
+
+	discovery := discoverStarCraftPaths(fakeDiscoveryEnv("windows", profile, map[string]string{
+		"USERPROFILE": profile,
+		"OneDrive":    oneDrive,
+	}))
+
+	if len(discovery.ReplayRoots) != 1 {
+		t.Fatalf("expected the OneDrive replay root only, got %+v", discovery.ReplayRoots)
+	}
+	want := filepath.Join(oneDrive, "Documents", "StarCraft", "Maps", "Replays", "AutoSave")
+	if got := discovery.ReplayRoots[0]; got.Path != want || got.Reason != "Documents folder redirected to OneDrive" {
+		t.Fatalf("unexpected replay root: %+v", got)
+	}
+	if len(discovery.SettingsPaths) != 2 || discovery.SettingsPaths[0].Reason != "Windows Documents folder (USERPROFILE)" {
+		t.Fatalf("expected USERPROFILE settings first, got %+v", discovery.SettingsPaths)
+	}
+}
+
+func TestDiscoverStarCraftPathsLinuxPrefixes(t *testing.T) {
+	home := t.TempDir()
+	proton := filepath.Join(home, ".steam", "steam", "steamapps", "compatdata", "4242", "pfx")
+	makeStarCraftDocuments(t, filepath.Join(proton, "drive_c", "users", "steamuser", "Documents", "StarCraft"), true, true)
+	wine := filepath.Join(home, ".wine")
+	makeStarCraftDocuments(t, filepath.Join(wine, "drive_c", "users", "alpha", "My Documents", "StarCraft"), true, false)
+	makeStarCraftDocuments(t, filepath.Join(wine, "drive_c", "users", "Public", "Documents", "StarCraft"), true, true)
+
+	discovery := discoverStarCraftPaths(fakeDiscoveryEnv("linux", home, nil))
+
+	if len(discovery.ReplayRoots) != 2 {
+		t.Fatalf("expected the Wine and Proton replay roots, got %+v", discovery.ReplayRoots)
+	}
+	if got := discovery.ReplayRoots[0].Reason; got != "default Wine prefix, user alpha" {
+		t.Fatalf("expected the Wine prefix first, got %q", got)
+	}
+	if got := discovery.ReplayRoots[1].Reason; got != "Proton prefix for Steam app 4242, user steamuser" {
+		t.Fatalf("unexpected Proton reason: %q", got)
+	}
+	if len(discovery.SettingsPaths) != 1 || discovery.SettingsPaths[0].Reason != "Proton prefix for Steam app 4242, user steamuser" {
+		t.Fatalf("expected the Proton settings only, got %+v", discovery.SettingsPaths)
+	}
+}
+
+func TestDiscoverStarCraftPathsWinePrefixFromEnv(t *testing.T) {
+	home := t.TempDir()
+	prefix := filepath.Join(home, "prefixes", "bw")
+	makeStarCraftDocuments(t, filepath.Join(prefix, "drive_c", "users", "alpha", "Documents", "StarCraft"), true, true)
+
+	discovery := discoverStarCraftPaths(fakeDiscoveryEnv("linux", home, map[string]string{"WINEPREFIX": prefix}))
+
+	if len(discovery.ReplayRoots) != 1 || discovery.ReplayRoots[0].Reason != "Wine prefix from WINEPREFIX, user alpha" {
+		t.Fatalf("unexpected replay roots: %+v", discovery.ReplayRoots)
+	}
+}
+
+func TestDiscoverStarCraftPathsMacOS(t *testing.T) {
+	home := t.TempDir()
+	makeStarCraftDocuments(t, filepath.Join(home, "Library", "Application Support", "Blizzard", "StarCraft"), true, true)
+
+	discovery := discoverStarCraftPaths(fakeDiscoveryEnv("darwin", home, nil))
+
+	if len(discovery.ReplayRoots) != 1 || discovery.ReplayRoots[0].Reason != "macOS Application Support folder" {
+		t.Fatalf("unexpected replay roots: %+v", discovery.ReplayRoots)
+	}
+	if len(discovery.SettingsPaths) != 1 {
+		t.Fatalf("expected one settings file, got %+v", discovery.SettingsPaths)
+	}
+}
+
+func TestDiscoverStarCraftPathsNothingFound(t *testing.T) {
+	home := t.TempDir()
+	env := fakeDiscoveryEnv("linux", home, nil)
+
+	discovery := discoverStarCraftPaths(env)
+
+	if len(discovery.ReplayRoots) != 0 || len(discovery.SettingsPaths) != 0 {
+		t.Fatalf("expected nothing in an empty home, got %+v", discovery)
+	}
+	if got, want := defaultStarCraftDocumentsDir(env), filepath.Join(home, "Documents", "StarCraft"); got != want {
+		t.Fatalf("expected default %q, got %q", want, got)
+	}
+}
+
+func fakeDiscoveryEnv(goos, home string, vars map[string]string) discoveryEnv {
+	return discoveryEnv{goos: goos, home: home, getenv: func(key string) string { return vars[key] }}
+}
+
+// makeStarCraftDocuments creates a StarCraft Documents folder with an
+// AutoSave folder and a CSettings.json as asked.
+func makeStarCraftDocuments(t *testing.T, dir string, autoSave, settings bool) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if autoSave {
+		if err := os.MkdirAll(filepath.Join(dir, "Maps", "Replays", "AutoSave"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if settings {
+		if err := os.WriteFile(filepath.Join(dir, "CSettings.json"), []byte(`{}`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
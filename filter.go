@@ -0,0 +1,63 @@
+package main
+
+import (
+	screp "github.com/icza/screp/rep"
+	"github.com/icza/screp/rep/repcore"
+)
+
+// allowsHeader checks everything the replay header alone can tell. Observers
+// are only detected from the commands, so the header's player count is an
+// upper bound and MaxPlayers waits for allowsAnalysis.
+func (f ScanFilter) allowsHeader(header *screp.Header) bool {
+	if header == nil {
+		return false
+	}
+	if !f.From.IsZero() && header.StartTime.Before(f.From) {
+		return false
+	}
+	if !f.Until.IsZero() && !header.StartTime.Before(f.Until) {
+		return false
+	}
+	if f.MeleeOnly && header.Type != repcore.GameTypeMelee && header.Type != repcore.GameType1on1 {
+		return false
+	}
+	if header.Duration() < f.MinDuration {
+		return false
+	}
+	if f.ExcludeComputers {
+		for _, player := range header.Players {
+			if player.Type == repcore.PlayerTypeComputer {
+				return false
+			}
+		}
+	}
+
+	return len(header.Players) >= f.MinPlayers
+}
+
+// allowsAnalysis applies the rules that need observers detected once the
+// replay was analyzed: the player count leaves observers out, and with
+// ExcludeObservers the target must have played.
+func (f ScanFilter) allowsAnalysis(analysis replayAnalysis) bool {
+	if !analysis.Result.Matched {
+		return true
+	}
+	if f.ExcludeObservers && analysis.TargetObserved {
+		return false
+	}
+	if analysis.ActivePlayers < f.MinPlayers {
+		return false
+	}
+	return f.MaxPlayers == 0 || analysis.ActivePlayers <= f.MaxPlayers
+}
+
+// activePlayers counts the players screp didn't detect as observers.
+func activePlayers(rep *screp.Replay) int {
+	count := 0
+	for _, player := range rep.Header.Players {
+		if !player.Observer {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	screp "github.com/icza/screp/rep"
+	"github.com/icza/screp/rep/repcore"
+)
+
+func TestScanFilterAllowsHeader(t *testing.T) {
+	start := time.Date(2024, 5, 12, 20, 0, 0, 0, time.UTC)
+	header := func(gameType *repcore.GameType, seconds int, playerTypes ...*repcore.PlayerType) *screp.Header {
+		h := &screp.Header{StartTime: start, Type: gameType, Frames: secondFrame(seconds)}
+		for i, playerType := range playerTypes {
+			h.Players = append(h.Players, &screp.Player{ID: byte(i + 1), Type: playerType})
+		}
+		return h
+	}
+	human := repcore.PlayerTypeHuman
+	oneVsOne := header(repcore.GameTypeMelee, 600, human, human)
+
+	tests := []struct {
+		name   string
+		filter ScanFilter
+		header *screp.Header
+		want   bool
+	}{
+		{"no filter", ScanFilter{}, header(repcore.GameTypeUMS, 10, human), true},
+		{"inside date range", ScanFilter{From: start.AddDate(0, 0, -1), Until: start.AddDate(0, 0, 1)}, oneVsOne, true},
+		{"before from", ScanFilter{From: start.Add(time.Hour)}, oneVsOne, false},
+		{"until is exclusive", ScanFilter{Until: start}, oneVsOne, false},
+		{"melee only keeps 1v1 type", ScanFilter{MeleeOnly: true}, header(repcore.GameType1on1, 600, human, human), true},
+		{"melee only drops UMS", ScanFilter{MeleeOnly: true}, header(repcore.GameTypeUMS, 600, human, human), false},
+		{"too short", ScanFilter{MinDuration: 11 * time.Minute}, oneVsOne, false},
+		{"long enough", ScanFilter{MinDuration: 10 * time.Minute}, oneVsOne, true},
+		{"max players waits for observers", ScanFilter{MaxPlayers: 2}, header(repcore.GameTypeMelee, 600, human, human, human), true},
+		{"under min players", ScanFilter{MinPlayers: 2}, header(repcore.GameTypeMelee, 600, human), false},
+		{"computer opponent", ScanFilter{ExcludeComputers: true}, header(repcore.GameTypeMelee, 600, human, repcore.PlayerTypeComputer), false},
+	}
+
+	for _, test := range tests {
+		if got := test.filter.allowsHeader(test.header); got != test.want {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, got)
+		}
+	}
+}
+
+func TestScanFilterAllowsAnalysis(t *testing.T) {
+	filter := ScanFilter{MinPlayers: 2, MaxPlayers: 2, ExcludeObservers: true}
+	played := replayAnalysis{ActivePlayers: 2, Result: ReplayMacroResult{Matched: true}}
+	observed := replayAnalysis{TargetObserved: true, ActivePlayers: 2, Result: ReplayMacroResult{Matched: true}}
+	teamGame := replayAnalysis{ActivePlayers: 4, Result: ReplayMacroResult{Matched: true}}
+
+	if !filter.allowsAnalysis(played) {
+		t.Fatalf("expected a 1v1 with observers to pass")
+	}
+	if filter.allowsAnalysis(observed) {
+		t.Fatalf("expected a game the target observed to be filtered")
+	}
+	if filter.allowsAnalysis(teamGame) {
+		t.Fatalf("expected a 2v2 to be filtered")
+	}
+
+	// The 1v1 preset doesn't exclude observers but still doesn't count them.
+	oneVsOne := ScanFilter{MinPlayers: 2, MaxPlayers: 2}
+	if !oneVsOne.allowsAnalysis(played) || !oneVsOne.allowsAnalysis(observed) {
+		t.Fatalf("expected observed and casted 1v1s to pass without ExcludeObservers")
+	}
+	if oneVsOne.allowsAnalysis(teamGame) {
+		t.Fatalf("expected a 2v2 to be filtered without ExcludeObservers")
+	}
+}
+
+func TestActivePlayersSkipsObservers(t *testing.T) {
+	rep := terranReplayWithCommands(nil, 60)
+	addOpponent(rep, "bravo", repcore.RaceZerg)
+	rep.Header.Players = append(rep.Header.Players, &screp.Player{ID: 3, Name: "caster", Team: 3, Observer: true})
+
+	if got := activePlayers(rep); got != 2 {
+		t.Fatalf("expected 2 active players, got %d", got)
+	}
+}
+
+func TestSummarizeReplayAnalysesCountsFiltered(t *testing.T) {
+	analyses := []replayAnalysis{
+		{Result: ReplayMacroResult{Matched: true, SupplyBlockedSeconds: 10}},
+		{Filtered: true},
+		{Filtered: true},
+		{Skipped: true},
+		{},
+	}
+
+	summary := summarizeReplayAnalyses(ScanTarget{DisplayLabel: "alpha"}, analyses)
+
+	if summary.MatchedReplays != 1 || summary.FilteredReplays != 2 || summary.SkippedReplays != 1 {
+		t.Fatalf("unexpected counts: %+v", summary)
+	}
+}
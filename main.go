@@ -1,8 +1,9 @@
-//go:build windows
+//go:build windows || darwin || gui
 
 package main
 
 import (
+	"context"
 	"fmt"
 
 	"fyne.io/fyne/v2"
@@ -27,14 +28,34 @@ func main() {
 	ui := CreateUI(identity)
 	ui.ScanButton.OnTapped = func() {
 		target := resolveScanTarget(identity, ui.ManualEntry.Text)
+		target.Analysis.FilterIneffective = ui.FilterCheck.Checked
+		target.Roots = parseReplayRoots(ui.RootsEntry.Text)
+		target.CachePath = defaultReplayCachePath()
+		filter, err := parseScanFilter(
+			ui.FromEntry.Text, ui.UntilEntry.Text, ui.MinLengthEntry.Text,
+			ui.MeleeCheck.Checked, ui.OneVsOneCheck.Checked, ui.ComputersCheck.Checked, ui.ObserversCheck.Checked,
+		)
+		if err != nil {
+			ui.StatusLabel.SetText("Error: " + err.Error())
+			return
+		}
+		target.Filter = filter
 		UpdateSummaryUI(ui.SummaryLabel, nil)
-		ui.SupplyChart.SetSeries(make([]int, chartBucketCount))
-		ui.WorkerChart.SetSeries(make([]int, chartBucketCount))
+		UpdateChartViewUI(ui, nil)
 		ShowProgress(ui.Progress, ui.StatusLabel, "Scanning replay files...")
 		ui.ScanButton.Disable()
 
+		ctx, cancel := context.WithCancel(context.Background())
+		ui.CancelButton.OnTapped = func() {
+			cancel()
+			ui.CancelButton.Disable()
+			ui.StatusLabel.SetText("Canceling scan...")
+		}
+		ui.CancelButton.Enable()
+
 		go func() {
-			summary, err := scanMacroStats(target, func(p float64) {
+			defer cancel()
+			summary, err := scanMacroStats(ctx, target, func(p float64) {
 				fyne.Do(func() {
 					ui.Progress.SetValue(p)
 				})
@@ -44,16 +65,21 @@ func main() {
 					ui.StatusLabel.SetText("Error: " + err.Error())
 					ui.Progress.Hide()
 					ui.ScanButton.Enable()
+					ui.CancelButton.Disable()
 				})
 				return
 			}
 
 			fyne.Do(func() {
 				UpdateSummaryUI(ui.SummaryLabel, summary)
-				ui.SupplyChart.SetSeries(summary.SupplyChart)
-				ui.WorkerChart.SetSeries(summary.WorkerChart)
-				HideProgress(ui.Progress, ui.StatusLabel, "Scan completed successfully!")
+				UpdateChartViewUI(ui, summary)
 				ui.ScanButton.Enable()
+				ui.CancelButton.Disable()
+				if summary.Incomplete {
+					HideProgress(ui.Progress, ui.StatusLabel, "Scan canceled; showing partial results.")
+					return
+				}
+				HideProgress(ui.Progress, ui.StatusLabel, "Scan completed successfully!")
 
 				fyne.CurrentApp().SendNotification(&fyne.Notification{
 					Title: "Scan Complete",
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// mapVersionPattern matches version suffixes such as "1.3", "v2" or
+	// "2.1b" at the end of a map name.
+	mapVersionPattern = regexp.MustCompile(`(?i)\s+v?\d+(\.\d+)*[a-z]?$`)
+	// mapPlayerCountPattern matches the "(4)" player count prefix.
+	mapPlayerCountPattern = regexp.MustCompile(`^\(\d+\)\s*`)
+)
+
+// normalizeMapName strips color codes, the player count prefix and version
+// suffixes, so every release of a map collapses to one name.
+func normalizeMapName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.Join(strings.Fields(name), " ")
+	name = mapPlayerCountPattern.ReplaceAllString(name, "")
+	name = mapVersionPattern.ReplaceAllString(name, "")
+	return name
+}
+
+// mapAggregate collects the matched replays played on one map.
+type mapAggregate struct {
+	games         int
+	wins          int
+	losses        int
+	supplyBlocked int
+	workerIdle    int
+}
+
+// summarizeMaps averages the macro metrics per map, most played first. The
+// win rate only counts games with a known result.
+func summarizeMaps(results []ReplayMacroResult) []MapSummary {
+	aggregates := map[string]*mapAggregate{}
+	for _, result := range results {
+		if !result.Matched || result.MapName == "" {
+			continue
+		}
+		aggregate := aggregates[result.MapName]
+		if aggregate == nil {
+			aggregate = &mapAggregate{}
+			aggregates[result.MapName] = aggregate
+		}
+		aggregate.games++
+		aggregate.supplyBlocked += result.SupplyBlockedSeconds
+		aggregate.workerIdle += result.WorkerIdleSeconds
+		switch result.Outcome {
+		case outcomeWin:
+			aggregate.wins++
+		case outcomeLoss:
+			aggregate.losses++
+		}
+	}
+
+	maps := make([]MapSummary, 0, len(aggregates))
+	for name, aggregate := range aggregates {
+		summary := MapSummary{
+			Name:                    name,
+			Games:                   aggregate.games,
+			Wins:                    aggregate.wins,
+			Losses:                  aggregate.losses,
+			AvgSupplyBlockedSeconds: float64(aggregate.supplyBlocked) / float64(aggregate.games),
+			AvgWorkerIdleSeconds:    float64(aggregate.workerIdle) / float64(aggregate.games),
+		}
+		if decided := aggregate.wins + aggregate.losses; decided > 0 {
+			summary.WinRate = float64(aggregate.wins) / float64(decided)
+		}
+		maps = append(maps, summary)
+	}
+	sort.Slice(maps, func(i, j int) bool {
+		if maps[i].Games != maps[j].Games {
+			return maps[i].Games > maps[j].Games
+		}
+		return maps[i].Name < maps[j].Name
+	})
+	return maps
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+
+	screp "github.com/icza/screp/rep"
+	"github.com/icza/screp/rep/repcore"
+)
+
+func TestNormalizeMapName(t *testing.T) {
+	tests := map[string]string{
+		"Fighting Spirit 1.3":         "Fighting Spirit",
+		"\x03Fighting \x04Spirit 1.3": "Fighting Spirit",
+		"(4)Fighting Spirit":          "Fighting Spirit",
+		"Polypoid v1.65":              "Polypoid",
+		"Eclipse 1.2b":                "Eclipse",
+		"  Circuit   Breaker 1.0 ":    "Circuit Breaker",
+		"Python":                      "Python",
+	}
+
+	for name, want := range tests {
+		if got := normalizeMapName(name); got != want {
+			t.Errorf("normalizeMapName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestAnalyzeReplayRecordsMapAndOutcome(t *testing.T) {
+	rep := terranReplayWithCommands(nil, 60)
+	addOpponent(rep, "bravo", repcore.RaceZerg)
+	rep.Header.Map = "\x06Fighting Spirit 1.3"
+	rep.Computed = &screp.Computed{WinnerTeam: 2}
+
+	result := analyzeMatchedReplay(rep, rep.Header.Players[0], AnalysisOptions{})
+
+	if result.MapName != "Fighting Spirit" || result.Outcome != outcomeLoss {
+		t.Fatalf("expected a loss on Fighting Spirit, got %q on %q", result.Outcome, result.MapName)
+	}
+}
+
+func TestAggregateMacroResultsMaps(t *testing.T) {
+	results := []ReplayMacroResult{
+		{Matched: true, MapName: "Fighting Spirit", Outcome: outcomeWin, SupplyBlockedSeconds: 10, WorkerIdleSeconds: 20},
+		{Matched: true, MapName: "Fighting Spirit", Outcome: outcomeLoss, SupplyBlockedSeconds: 30, WorkerIdleSeconds: 40},
+		{Matched: true, MapName: "Fighting Spirit", Outcome: outcomeWin, SupplyBlockedSeconds: 20, WorkerIdleSeconds: 30},
+		{Matched: true, MapName: "Python", Outcome: outcomeUnknown, SupplyBlockedSeconds: 60},
+	}
+
+	summary := aggregateMacroResults(ScanTarget{DisplayLabel: "alpha"}, results, 0)
+
+	if len(summary.Maps) != 2 {
+		t.Fatalf("expected 2 maps, got %+v", summary.Maps)
+	}
+	spirit := summary.Maps[0]
+	if spirit.Name != "Fighting Spirit" || spirit.Games != 3 || spirit.Wins != 2 || spirit.Losses != 1 {
+		t.Fatalf("unexpected Fighting Spirit record: %+v", spirit)
+	}
+	if spirit.AvgSupplyBlockedSeconds != 20 || spirit.AvgWorkerIdleSeconds != 30 {
+		t.Fatalf("unexpected Fighting Spirit averages: %+v", spirit)
+	}
+	if python := summary.Maps[1]; python.WinRate != 0 || python.Wins+python.Losses != 0 {
+		t.Fatalf("expected no win rate without known results, got %+v", python)
+	}
+}
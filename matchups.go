@@ -0,0 +1,47 @@
+package main
+
+import (
+	"sort"
+
+	screp "github.com/icza/screp/rep"
+)
+
+// fillMatchup records the player's race, the opponent's race and the
+// matchup, written from the player's side ("TvZ"). Team games use the first
+// opponent, and replays without an opponent get no matchup.
+func fillMatchup(result *ReplayMacroResult, rep *screp.Replay, player *screp.Player) {
+	if rep == nil || rep.Header == nil || player == nil || player.Race == nil {
+		return
+	}
+	result.PlayerRace = string(player.Race.Letter)
+	opponent := opponentRace(rep, player)
+	if opponent == 0 {
+		return
+	}
+	result.OpponentRace = string(opponent)
+	result.Matchup = result.PlayerRace + "v" + result.OpponentRace
+}
+
+// breakdownMacroResults summarizes the matched replays separately for each
+// key, sorted by key. Replays with an empty key are left out.
+func breakdownMacroResults(results []ReplayMacroResult, key func(ReplayMacroResult) string) []MacroBreakdown {
+	groups := map[string][]ReplayMacroResult{}
+	for _, result := range results {
+		if !result.Matched || key(result) == "" {
+			continue
+		}
+		groups[key(result)] = append(groups[key(result)], result)
+	}
+
+	breakdowns := make([]MacroBreakdown, 0, len(groups))
+	for label, group := range groups {
+		breakdowns = append(breakdowns, MacroBreakdown{
+			Label:   label,
+			Summary: summarizeMacroMetrics(label, group),
+		})
+	}
+	sort.Slice(breakdowns, func(i, j int) bool {
+		return breakdowns[i].Label < breakdowns[j].Label
+	})
+	return breakdowns
+}
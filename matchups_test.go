@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	screp "github.com/icza/screp/rep"
+	"github.com/icza/screp/rep/repcore"
+)
+
+func TestAnalyzeReplayRecordsMatchup(t *testing.T) {
+	rep := terranReplayWithCommands([]timedCmd{buildWorker(0)}, 60)
+	addOpponent(rep, "bravo", repcore.RaceZerg)
+	rep.Header.Players = append(rep.Header.Players, &screp.Player{
+		ID: 3, Name: "watcher", Race: repcore.RaceProtoss, Team: 3, Observer: true,
+	})
+
+	result := analyzeMatchedReplay(rep, rep.Header.Players[0], AnalysisOptions{})
+
+	if result.PlayerRace != "T" || result.OpponentRace != "Z" || result.Matchup != "TvZ" {
+		t.Fatalf("expected TvZ, got %q vs %q (%q)", result.PlayerRace, result.OpponentRace, result.Matchup)
+	}
+}
+
+func TestAggregateMacroResultsMatchups(t *testing.T) {
+	results := []ReplayMacroResult{
+		{Matched: true, Matchup: "TvZ", SupplyBlockedSeconds: 10, SupplyChart: chartSeriesWithValue(1, 10)},
+		{Matched: true, Matchup: "TvP", SupplyBlockedSeconds: 90, SupplyChart: chartSeriesWithValue(4, 90)},
+		{Matched: true, Matchup: "TvZ", SupplyBlockedSeconds: 30, SupplyChart: chartSeriesWithValue(1, 30)},
+		{Matched: true, SupplyBlockedSeconds: 5},
+	}
+
+	summary := aggregateMacroResults(ScanTarget{DisplayLabel: "alpha"}, results, 0)
+
+	if len(summary.Matchups) != 2 || summary.Matchups[0].Label != "TvP" || summary.Matchups[1].Label != "TvZ" {
+		t.Fatalf("expected TvP and TvZ breakdowns, got %+v", summary.Matchups)
+	}
+	tvz := summary.Matchups[1].Summary
+	if tvz.MatchedReplays != 2 || tvz.AvgSupplyBlockedSeconds != 20 || tvz.SupplyRating != "Solid" {
+		t.Fatalf("unexpected TvZ summary: %+v", tvz)
+	}
+	if tvz.SupplyChart[1] != 40 || tvz.SupplyChart[4] != 0 {
+		t.Fatalf("expected TvZ chart series only, got %v", tvz.SupplyChart)
+	}
+	if summary.MatchedReplays != 4 {
+		t.Fatalf("expected the overall summary to keep every replay, got %d", summary.MatchedReplays)
+	}
+}
+
+func addOpponent(rep *screp.Replay, name string, race *repcore.Race) *screp.Player {
+	rep.Header.Players[0].Team = 1
+	opponent := &screp.Player{
+		ID:   2,
+		Name: name,
+		Race: race,
+		Type: repcore.PlayerTypeHuman,
+		Team: 2,
+	}
+	rep.Header.Players = append(rep.Header.Players, opponent)
+	return opponent
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/icza/screp/rep/repcmd"
+	"github.com/icza/screp/rep/repcore"
+)
+
+// droneMorphBuildings are the Zerg structures a drone morphs into, using up
+// the drone and freeing its supply. Lair, Hive, Greater Spire and the
+// colony upgrades morph from a building instead.
+var droneMorphBuildings = map[uint16]bool{
+	repcmd.UnitIDHatchery:         true,
+	repcmd.UnitIDExtractor:        true,
+	repcmd.UnitIDSpawningPool:     true,
+	repcmd.UnitIDEvolutionChamber: true,
+	repcmd.UnitIDHydraliskDen:     true,
+	repcmd.UnitIDSpire:            true,
+	repcmd.UnitIDQueensNest:       true,
+	repcmd.UnitIDUltraliskCavern:  true,
+	repcmd.UnitIDDefilerMound:     true,
+	repcmd.UnitIDCreepColony:      true,
+	repcmd.UnitIDNydusCanal:       true,
+}
+
+// unitMorph describes a unit that morphs from existing units instead of
+// being trained.
+type unitMorph struct {
+	source uint16
+	count  int
+}
+
+// unitMorphs maps morphed units to the units they are made from. Archons
+// merge two templar.
+var unitMorphs = map[uint16]unitMorph{
+	unitIDLurker:     {source: unitIDHydralisk, count: 1},
+	unitIDGuardian:   {source: unitIDMutalisk, count: 1},
+	unitIDDevourer:   {source: unitIDMutalisk, count: 1},
+	unitIDArchon:     {source: unitIDHighTemplar, count: 2},
+	unitIDDarkArchon: {source: unitIDDarkTemplar, count: 2},
+}
+
+// morphSupplyDeltaHalf is the supply a morph adds on top of what its source
+// units already use.
+func morphSupplyDeltaHalf(unitID uint16) int {
+	morph := unitMorphs[unitID]
+	return unitSupplyCostHalf[unitID] - unitSupplyCostHalf[morph.source]*morph.count
+}
+
+// handleUnitMorph starts a Lurker, Guardian, Devourer or Archon morph. Only
+// the supply difference is added when the morph finishes.
+func (s *replayState) handleUnitMorph(frame repcore.Frame, unitID uint16) {
+	delta := morphSupplyDeltaHalf(unitID)
+	if delta > 0 && s.usedSupplyHalf+delta > s.supplyCapHalf() && !s.isMaxed() {
+		s.supplyBlockedUntil = s.nextSupplyReliefFrame(frame)
+	}
+
+	s.recordBuildOrder(frame, buildOrderKindMorph, unitName(unitID))
+	s.bank.spend(unitCosts[unitID])
+	s.queuedSupplyHalf += delta
+	order := s.startOrder(pendingOrder{
+		kind:        orderKindMorph,
+		unitID:      unitID,
+		buildFrames: buildFramesOf(unitID),
+		cost:        unitCosts[unitID],
+		supplyHalf:  delta,
+		name:        unitName(unitID),
+	})
+	s.startTraining(frame, order)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/icza/screp/rep/repcmd"
+	"github.com/icza/screp/rep/repcore"
+)
+
+func TestAnalyzeReplayZergBuildingsUseADrone(t *testing.T) {
+	rep := zergReplayWithCommands([]timedCmd{
+		buildBuildingAtFrame(10, repcmd.UnitIDSpawningPool),
+		buildBuildingAtFrame(20, repcmd.UnitIDExtractor),
+		morphUnitAtFrame(30, unitIDDrone),
+	}, 60)
+	result := analyzeMatchedReplay(rep, rep.Header.Players[0], AnalysisOptions{})
+
+	// Four starting drones, two of them morphed into buildings.
+	if got := result.BuildOrder[2].Supply; got != 2 {
+		t.Fatalf("expected 2 supply after two drone morphs, got %d in %+v", got, result.BuildOrder)
+	}
+}
+
+func TestAnalyzeReplayUnitMorphSupplyDelta(t *testing.T) {
+	tests := []struct {
+		race   *repcore.Race
+		morph  timedCmd
+		worker uint16
+		want   int
+	}{
+		// A Lurker uses one supply more than its Hydralisk.
+		{repcore.RaceZerg, morphUnitAtFrame(100, unitIDLurker), unitIDDrone, 5},
+		{repcore.RaceZerg, morphUnitAtFrame(100, unitIDGuardian), unitIDDrone, 4},
+		{repcore.RaceProtoss, baseCmdAtFrame(100, repcmd.TypeIDMergeArchon), unitIDProbe, 4},
+	}
+
+	for _, test := range tests {
+		rep := replayWithCommands(test.race, []timedCmd{
+			test.morph,
+			trainUnitAtFrame(800, test.worker),
+		}, 60)
+		result := analyzeMatchedReplay(rep, rep.Header.Players[0], AnalysisOptions{})
+
+		if len(result.BuildOrder) != 2 || result.BuildOrder[0].Kind != buildOrderKindMorph {
+			t.Fatalf("expected the morph and the worker in the build order, got %+v", result.BuildOrder)
+		}
+		if got := result.BuildOrder[1].Supply; got != test.want {
+			t.Errorf("%s: expected %d supply after the morph, got %d", result.BuildOrder[0].Action, test.want, got)
+		}
+	}
+}
+
+func TestReplayStateCanceledDroneMorphReturnsTheDrone(t *testing.T) {
+	for unitID := range droneMorphBuildings {
+		state := newTestReplayState(repcore.RaceZerg)
+		state.availableSupplyHalf = 18
+		state.usedSupplyHalf = 8
+		state.workerCount = 4
+
+		state.handleBuildCommand(0, unitID)
+		state.handleCancelCommand(10, baseCmdAtFrame(10, repcmd.TypeIDCancelBuild).toCmd(1))
+
+		if state.workerCount != 4 || state.usedSupplyHalf != 8 {
+			t.Errorf("%s: expected the drone back, got %d workers using %d half supply",
+				unitName(unitID), state.workerCount, state.usedSupplyHalf)
+		}
+	}
+}
+
+func TestMorphTargetsHaveBuildTimes(t *testing.T) {
+	var targets []uint16
+	for unitID := range droneMorphBuildings {
+		targets = append(targets, unitID)
+	}
+	for unitID := range unitMorphs {
+		targets = append(targets, unitID)
+	}
+	targets = append(targets, repcmd.UnitIDLair, repcmd.UnitIDHive, repcmd.UnitIDGreaterSpire,
+		repcmd.UnitIDSunkenColony, repcmd.UnitIDSporeColony)
+
+	for _, unitID := range targets {
+		if unitBuildFrames[unitID] == 0 {
+			t.Errorf("%s has no build time", unitName(unitID))
+		}
+	}
+}
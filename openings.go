@@ -0,0 +1,153 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// unclassifiedOpening is reported when no opening rule matches a build order.
+const unclassifiedOpening = "Unclassified"
+
+// openingStep is one order an opening rule expects. maxSupply is the latest
+// supply the order may be placed at, or 0 for no limit.
+type openingStep struct {
+	action    string
+	maxSupply int
+}
+
+// openingRule recognizes an opening from a build order. The steps must
+// appear in order, and none of the actions in without may come before the
+// last step. vs lists the opponent race letters the rule applies to; an empty
+// vs matches every matchup. Rules are tried in order and the first match
+// wins, so more specific openings come first.
+type openingRule struct {
+	name    string
+	race    rune
+	vs      string
+	steps   []openingStep
+	without []string
+}
+
+var openingRules = []openingRule{
+	{name: "4 Pool", race: 'Z', steps: []openingStep{{"Spawning Pool", 5}}},
+	{name: "9 Pool", race: 'Z', steps: []openingStep{{"Spawning Pool", 9}}, without: []string{"Overlord", "Hatchery"}},
+	{name: "Overpool", race: 'Z', steps: []openingStep{{"Overlord", 9}, {"Spawning Pool", 10}}, without: []string{"Hatchery"}},
+	{name: "12 Pool", race: 'Z', steps: []openingStep{{"Spawning Pool", 12}}, without: []string{"Hatchery"}},
+	{name: "12 Hatch", race: 'Z', steps: []openingStep{{"Hatchery", 12}}, without: []string{"Spawning Pool"}},
+	{name: "BBS", race: 'T', steps: []openingStep{{"Barracks", 0}, {"Barracks", 0}}, without: []string{"Supply Depot"}},
+	{name: "CC First", race: 'T', steps: []openingStep{{"Command Center", 16}}, without: []string{"Barracks"}},
+	{name: "2 Rax", race: 'T', steps: []openingStep{{"Barracks", 0}, {"Barracks", 0}}, without: []string{"Refinery", "Command Center"}},
+	{name: "Siege Expand", race: 'T', vs: "P", steps: []openingStep{{"Barracks", 0}, {"Factory", 0}, {"Command Center", 0}}},
+	{name: "1 Rax FE", race: 'T', steps: []openingStep{{"Barracks", 0}, {"Command Center", 20}}, without: []string{"Factory"}},
+	{name: "1 Rax Fact", race: 'T', steps: []openingStep{{"Barracks", 0}, {"Factory", 0}}, without: []string{"Command Center"}},
+	{name: "Forge FE", race: 'P', vs: "Z", steps: []openingStep{{"Forge", 0}, {"Nexus", 0}}, without: []string{"Gateway"}},
+	{name: "Nexus First", race: 'P', steps: []openingStep{{"Nexus", 14}}, without: []string{"Gateway", "Forge"}},
+	{name: "2 Gate", race: 'P', steps: []openingStep{{"Gateway", 0}, {"Gateway", 0}}, without: []string{"Cybernetics Core"}},
+	{name: "1 Gate Core", race: 'P', steps: []openingStep{{"Gateway", 0}, {"Cybernetics Core", 0}}},
+}
+
+// classifyOpening returns the name of the first rule matching the build
+// order, or unclassifiedOpening.
+func classifyOpening(rules []openingRule, race, vs rune, buildOrder []BuildOrderEntry) string {
+	for _, rule := range rules {
+		if rule.race != race {
+			continue
+		}
+		if rule.vs != "" && !strings.ContainsRune(rule.vs, vs) {
+			continue
+		}
+		if rule.matches(buildOrder) {
+			return rule.name
+		}
+	}
+	return unclassifiedOpening
+}
+
+// matches walks the build order once. An order for a later step that shows
+// up before the current one means the opening was played in another order.
+func (r openingRule) matches(buildOrder []BuildOrderEntry) bool {
+	next := 0
+	for _, entry := range buildOrder {
+		if next == len(r.steps) {
+			return true
+		}
+		if containsString(r.without, entry.Action) {
+			return false
+		}
+		step := r.steps[next]
+		if entry.Action == step.action {
+			if step.maxSupply > 0 && entry.Supply > step.maxSupply {
+				return false
+			}
+			next++
+			continue
+		}
+		for _, later := range r.steps[next+1:] {
+			if entry.Action == later.action {
+				return false
+			}
+		}
+	}
+	return next == len(r.steps)
+}
+
+// openingAggregate collects the matched replays of one opening.
+type openingAggregate struct {
+	games          int
+	supplyBlocked  int
+	workerIdle     int
+	productionIdle int
+	unspent        int
+}
+
+func (a *openingAggregate) add(result ReplayMacroResult) {
+	a.games++
+	a.supplyBlocked += result.SupplyBlockedSeconds
+	a.workerIdle += result.WorkerIdleSeconds
+	a.productionIdle += result.ProductionIdleSeconds
+	a.unspent += result.AvgUnspentResources
+}
+
+// summarizeOpenings averages the macro metrics per opening, most played
+// first.
+func summarizeOpenings(results []ReplayMacroResult) []OpeningSummary {
+	aggregates := map[string]*openingAggregate{}
+	for _, result := range results {
+		if !result.Matched || result.Opening == "" {
+			continue
+		}
+		if aggregates[result.Opening] == nil {
+			aggregates[result.Opening] = &openingAggregate{}
+		}
+		aggregates[result.Opening].add(result)
+	}
+
+	openings := make([]OpeningSummary, 0, len(aggregates))
+	for name, aggregate := range aggregates {
+		games := float64(aggregate.games)
+		openings = append(openings, OpeningSummary{
+			Name:                     name,
+			Games:                    aggregate.games,
+			AvgSupplyBlockedSeconds:  float64(aggregate.supplyBlocked) / games,
+			AvgWorkerIdleSeconds:     float64(aggregate.workerIdle) / games,
+			AvgProductionIdleSeconds: float64(aggregate.productionIdle) / games,
+			AvgUnspentResources:      float64(aggregate.unspent) / games,
+		})
+	}
+	sort.Slice(openings, func(i, j int) bool {
+		if openings[i].Games != openings[j].Games {
+			return openings[i].Games > openings[j].Games
+		}
+		return openings[i].Name < openings[j].Name
+	})
+	return openings
+}
+
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
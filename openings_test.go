@@ -0,0 +1,134 @@
+package main
+
+import "testing"
+
+func TestClassifyOpening(t *testing.T) {
+	tests := []struct {
+		name       string
+		race, vs   rune
+		buildOrder []BuildOrderEntry
+		want       string
+	}{
+		{
+			name: "9 pool",
+			race: 'Z', vs: 'T',
+			buildOrder: []BuildOrderEntry{
+				buildOrderEntry(4, "Drone"),
+				buildOrderEntry(9, "Spawning Pool"),
+				buildOrderEntry(8, "Drone"),
+				buildOrderEntry(9, "Overlord"),
+			},
+			want: "9 Pool",
+		},
+		{
+			name: "overpool",
+			race: 'Z', vs: 'P',
+			buildOrder: []BuildOrderEntry{
+				buildOrderEntry(9, "Overlord"),
+				buildOrderEntry(9, "Spawning Pool"),
+				buildOrderEntry(11, "Hatchery"),
+			},
+			want: "Overpool",
+		},
+		{
+			name: "12 hatch",
+			race: 'Z', vs: 'P',
+			buildOrder: []BuildOrderEntry{
+				buildOrderEntry(9, "Overlord"),
+				buildOrderEntry(12, "Hatchery"),
+				buildOrderEntry(11, "Spawning Pool"),
+			},
+			want: "12 Hatch",
+		},
+		{
+			name: "siege expand only versus protoss",
+			race: 'T', vs: 'P',
+			buildOrder: []BuildOrderEntry{
+				buildOrderEntry(9, "Supply Depot"),
+				buildOrderEntry(11, "Barracks"),
+				buildOrderEntry(12, "Refinery"),
+				buildOrderEntry(16, "Factory"),
+				buildOrderEntry(22, "Command Center"),
+			},
+			want: "Siege Expand",
+		},
+		{
+			name: "1 rax fact versus zerg",
+			race: 'T', vs: 'Z',
+			buildOrder: []BuildOrderEntry{
+				buildOrderEntry(9, "Supply Depot"),
+				buildOrderEntry(11, "Barracks"),
+				buildOrderEntry(12, "Refinery"),
+				buildOrderEntry(16, "Factory"),
+				buildOrderEntry(22, "Command Center"),
+			},
+			want: "1 Rax Fact",
+		},
+		{
+			name: "1 gate core",
+			race: 'P', vs: 'T',
+			buildOrder: []BuildOrderEntry{
+				buildOrderEntry(8, "Pylon"),
+				buildOrderEntry(10, "Gateway"),
+				buildOrderEntry(12, "Assimilator"),
+				buildOrderEntry(14, "Cybernetics Core"),
+				buildOrderEntry(17, "Gateway"),
+			},
+			want: "1 Gate Core",
+		},
+		{
+			name: "wrong race",
+			race: 'P', vs: 'Z',
+			buildOrder: []BuildOrderEntry{
+				buildOrderEntry(9, "Spawning Pool"),
+			},
+			want: unclassifiedOpening,
+		},
+	}
+
+	for _, test := range tests {
+		if got := classifyOpening(openingRules, test.race, test.vs, test.buildOrder); got != test.want {
+			t.Errorf("%s: expected %q, got %q", test.name, test.want, got)
+		}
+	}
+}
+
+func TestClassifyOpeningCustomRules(t *testing.T) {
+	rules := []openingRule{
+		{name: "Fast Lair", race: 'Z', steps: []openingStep{{"Spawning Pool", 0}, {"Lair", 0}}, without: []string{"Hatchery"}},
+	}
+	buildOrder := []BuildOrderEntry{
+		buildOrderEntry(9, "Spawning Pool"),
+		buildOrderEntry(10, "Extractor"),
+		buildOrderEntry(11, "Lair"),
+	}
+
+	if got := classifyOpening(rules, 'Z', 'Z', buildOrder); got != "Fast Lair" {
+		t.Fatalf("expected the custom rule to match, got %q", got)
+	}
+}
+
+func TestAggregateMacroResultsOpenings(t *testing.T) {
+	results := []ReplayMacroResult{
+		{Matched: true, Opening: "12 Hatch", SupplyBlockedSeconds: 10, WorkerIdleSeconds: 30},
+		{Matched: true, Opening: "9 Pool", SupplyBlockedSeconds: 40},
+		{Matched: true, Opening: "12 Hatch", SupplyBlockedSeconds: 20, WorkerIdleSeconds: 50},
+	}
+
+	summary := aggregateMacroResults(ScanTarget{DisplayLabel: "alpha"}, results, 0)
+
+	if len(summary.Openings) != 2 {
+		t.Fatalf("expected 2 openings, got %+v", summary.Openings)
+	}
+	hatch := summary.Openings[0]
+	if hatch.Name != "12 Hatch" || hatch.Games != 2 || hatch.AvgSupplyBlockedSeconds != 15 || hatch.AvgWorkerIdleSeconds != 40 {
+		t.Fatalf("unexpected 12 Hatch breakdown: %+v", hatch)
+	}
+	if summary.Openings[1].Name != "9 Pool" || summary.Openings[1].AvgSupplyBlockedSeconds != 40 {
+		t.Fatalf("unexpected 9 Pool breakdown: %+v", summary.Openings[1])
+	}
+}
+
+func buildOrderEntry(supply int, action string) BuildOrderEntry {
+	return BuildOrderEntry{Supply: supply, Action: action}
+}
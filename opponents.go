@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	screp "github.com/icza/screp/rep"
+)
+
+func opponentNames(opponents []*screp.Player) []string {
+	names := make([]string, 0, len(opponents))
+	for _, opponent := range opponents {
+		names = append(names, opponent.Name)
+	}
+	return names
+}
+
+// opponentAggregate collects the matched replays against one opponent.
+type opponentAggregate struct {
+	games          int
+	wins           int
+	losses         int
+	matchups       map[string]bool
+	supplyBlocked  int
+	workerIdle     int
+	productionIdle int
+	lastPlayed     time.Time
+}
+
+// summarizeOpponents builds the head-to-head history per opponent, most
+// played first. A team game counts for every opponent in it.
+func summarizeOpponents(results []ReplayMacroResult) []OpponentSummary {
+	aggregates := map[string]*opponentAggregate{}
+	for _, result := range results {
+		if !result.Matched {
+			continue
+		}
+		for _, name := range result.Opponents {
+			aggregate := aggregates[name]
+			if aggregate == nil {
+				aggregate = &opponentAggregate{matchups: map[string]bool{}}
+				aggregates[name] = aggregate
+			}
+			aggregate.games++
+			switch result.Outcome {
+			case outcomeWin:
+				aggregate.wins++
+			case outcomeLoss:
+				aggregate.losses++
+			}
+			if result.Matchup != "" {
+				aggregate.matchups[result.Matchup] = true
+			}
+			aggregate.supplyBlocked += result.SupplyBlockedSeconds
+			aggregate.workerIdle += result.WorkerIdleSeconds
+			aggregate.productionIdle += result.ProductionIdleSeconds
+			if result.StartTime.After(aggregate.lastPlayed) {
+				aggregate.lastPlayed = result.StartTime
+			}
+		}
+	}
+
+	opponents := make([]OpponentSummary, 0, len(aggregates))
+	for name, aggregate := range aggregates {
+		games := float64(aggregate.games)
+		matchups := make([]string, 0, len(aggregate.matchups))
+		for matchup := range aggregate.matchups {
+			matchups = append(matchups, matchup)
+		}
+		sort.Strings(matchups)
+		opponent := OpponentSummary{
+			Name:                     name,
+			Games:                    aggregate.games,
+			Wins:                     aggregate.wins,
+			Losses:                   aggregate.losses,
+			Matchups:                 matchups,
+			AvgSupplyBlockedSeconds:  float64(aggregate.supplyBlocked) / games,
+			AvgWorkerIdleSeconds:     float64(aggregate.workerIdle) / games,
+			AvgProductionIdleSeconds: float64(aggregate.productionIdle) / games,
+			LastPlayed:               aggregate.lastPlayed,
+		}
+		if decided := aggregate.wins + aggregate.losses; decided > 0 {
+			opponent.WinRate = float64(aggregate.wins) / float64(decided)
+		}
+		opponents = append(opponents, opponent)
+	}
+	sort.Slice(opponents, func(i, j int) bool {
+		if opponents[i].Games != opponents[j].Games {
+			return opponents[i].Games > opponents[j].Games
+		}
+		return opponents[i].Name < opponents[j].Name
+	})
+	return opponents
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	screp "github.com/icza/screp/rep"
+	"github.com/icza/screp/rep/repcore"
+)
+
+func TestFindMatchingPlayerReturnsOpponents(t *testing.T) {
+	rep := terranReplayWithCommands(nil, 60)
+	addOpponent(rep, "bravo", repcore.RaceZerg)
+	rep.Header.Players = append(rep.Header.Players, &screp.Player{ID: 3, Name: "caster", Team: 3, Observer: true})
+
+	player, opponents := findMatchingPlayer(rep, []string{"alpha"})
+
+	if player != rep.Header.Players[0] {
+		t.Fatalf("expected alpha to match, got %+v", player)
+	}
+	if names := opponentNames(opponents); len(names) != 1 || names[0] != "bravo" {
+		t.Fatalf("expected bravo as the only opponent, got %v", names)
+	}
+}
+
+func TestAggregateMacroResultsOpponents(t *testing.T) {
+	first := time.Date(2024, 3, 1, 20, 0, 0, 0, time.UTC)
+	last := time.Date(2024, 5, 12, 20, 0, 0, 0, time.UTC)
+	results := []ReplayMacroResult{
+		{Matched: true, Opponents: []string{"bravo"}, Matchup: "TvZ", Outcome: outcomeWin, SupplyBlockedSeconds: 10, WorkerIdleSeconds: 20, StartTime: first},
+		{Matched: true, Opponents: []string{"bravo"}, Matchup: "TvP", Outcome: outcomeLoss, SupplyBlockedSeconds: 30, WorkerIdleSeconds: 40, StartTime: last},
+		{Matched: true, Opponents: []string{"bravo"}, Matchup: "TvZ", Outcome: outcomeWin, SupplyBlockedSeconds: 20, ProductionIdleSeconds: 60, StartTime: first},
+		{Matched: true, Opponents: []string{"charlie"}, Outcome: outcomeUnknown},
+	}
+
+	summary := aggregateMacroResults(ScanTarget{DisplayLabel: "alpha"}, results, 0)
+
+	if len(summary.Opponents) != 2 {
+		t.Fatalf("expected 2 opponents, got %+v", summary.Opponents)
+	}
+	bravo := summary.Opponents[0]
+	if bravo.Name != "bravo" || bravo.Games != 3 || bravo.Wins != 2 || bravo.Losses != 1 {
+		t.Fatalf("unexpected record against bravo: %+v", bravo)
+	}
+	if len(bravo.Matchups) != 2 || bravo.Matchups[0] != "TvP" || bravo.Matchups[1] != "TvZ" {
+		t.Fatalf("unexpected matchups against bravo: %v", bravo.Matchups)
+	}
+	if bravo.AvgSupplyBlockedSeconds != 20 || bravo.AvgWorkerIdleSeconds != 20 || bravo.AvgProductionIdleSeconds != 20 {
+		t.Fatalf("unexpected averages against bravo: %+v", bravo)
+	}
+	if !bravo.LastPlayed.Equal(last) {
+		t.Fatalf("expected bravo last played on %v, got %v", last, bravo.LastPlayed)
+	}
+	if charlie := summary.Opponents[1]; charlie.WinRate != 0 || !charlie.LastPlayed.IsZero() {
+		t.Fatalf("unexpected record against charlie: %+v", charlie)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"github.com/icza/screp/rep/repcmd"
+	"github.com/icza/screp/rep/repcore"
+)
+
+const (
+	productionGreatThreshold = 90.0
+	productionSolidThreshold = 240.0
+
+	// maxProductionQueue is how many units a building can have queued,
+	// including the one in training.
+	maxProductionQueue = 5
+)
+
+// productionBuilding is a building that trains units one at a time from its
+// queue: a Command Center, Nexus or Hatchery for workers, or an army
+// production building. It is bound to a unit tag the first time the player
+// selects it to train. Hatcheries produce through their larvae instead.
+type productionBuilding struct {
+	tag        repcmd.UnitTag
+	bound      bool
+	queue      []*pendingOrder
+	larva      int
+	larvaTimer bool
+}
+
+// busy reports whether the building has a unit in training.
+func (p *productionBuilding) busy() bool {
+	return len(p.queue) > 0
+}
+
+func (p *productionBuilding) queueFull() bool {
+	return len(p.queue) >= maxProductionQueue
+}
+
+func (p *productionBuilding) removeFromQueue(order *pendingOrder) {
+	for i, queued := range p.queue {
+		if queued == order {
+			p.queue = append(p.queue[:i:i], p.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// armyProducerOf maps army units to the building that trains them.
+var armyProducerOf = map[uint16]uint16{
+	unitIDMarine:        repcmd.UnitIDBarracks,
+	unitIDFirebat:       repcmd.UnitIDBarracks,
+	unitIDMedic:         repcmd.UnitIDBarracks,
+	unitIDGhost:         repcmd.UnitIDBarracks,
+	unitIDVulture:       repcmd.UnitIDFactory,
+	unitIDSiegeTankTM:   repcmd.UnitIDFactory,
+	unitIDGoliath:       repcmd.UnitIDFactory,
+	unitIDWraith:        repcmd.UnitIDStarport,
+	unitIDDropship:      repcmd.UnitIDStarport,
+	unitIDScienceVessel: repcmd.UnitIDStarport,
+	unitIDBattlecruiser: repcmd.UnitIDStarport,
+	unitIDValkyrie:      repcmd.UnitIDStarport,
+	unitIDZealot:        repcmd.UnitIDGateway,
+	unitIDDragoon:       repcmd.UnitIDGateway,
+	unitIDHighTemplar:   repcmd.UnitIDGateway,
+	unitIDDarkTemplar:   repcmd.UnitIDGateway,
+	unitIDShuttle:       repcmd.UnitIDRoboticsFacility,
+	unitIDReaver:        repcmd.UnitIDRoboticsFacility,
+	unitIDObserver:      repcmd.UnitIDRoboticsFacility,
+	unitIDScout:         repcmd.UnitIDStargate,
+	unitIDCarrier:       repcmd.UnitIDStargate,
+	unitIDArbiter:       repcmd.UnitIDStargate,
+	unitIDCorsair:       repcmd.UnitIDStargate,
+}
+
+// armyProducerUnitIDs are the buildings tracked for production idle time.
+var armyProducerUnitIDs = map[uint16]bool{
+	repcmd.UnitIDBarracks:         true,
+	repcmd.UnitIDFactory:          true,
+	repcmd.UnitIDStarport:         true,
+	repcmd.UnitIDGateway:          true,
+	repcmd.UnitIDRoboticsFacility: true,
+	repcmd.UnitIDStargate:         true,
+}
+
+// producerFor picks the building a train order went to. The selected tag is
+// matched to its bound building, or binds the first unbound one. Without
+// selection data the building with the shortest queue takes the order.
+func producerFor(producers []*productionBuilding, selection *selectionTracker) *productionBuilding {
+	if tag, selected := selection.producer(); selected {
+		for _, producer := range producers {
+			if producer.bound && producer.tag == tag {
+				return producer
+			}
+		}
+		for _, producer := range producers {
+			if !producer.bound {
+				producer.tag = tag
+				producer.bound = true
+				return producer
+			}
+		}
+	}
+
+	var shortest *productionBuilding
+	for _, producer := range producers {
+		if shortest == nil || len(producer.queue) < len(shortest.queue) {
+			shortest = producer
+		}
+	}
+	return shortest
+}
+
+func (s *replayState) addArmyProducer(unitID uint16) {
+	s.armyProducers[unitID] = append(s.armyProducers[unitID], &productionBuilding{})
+}
+
+// idleArmyProducers counts finished army production buildings with an empty
+// queue.
+func (s *replayState) idleArmyProducers() int {
+	idle := 0
+	for _, producers := range s.armyProducers {
+		for _, producer := range producers {
+			if !producer.busy() {
+				idle++
+			}
+		}
+	}
+	return idle
+}
+
+// startTraining begins an order once it reaches the front of its building's
+// queue, or right away for units without a queue.
+func (s *replayState) startTraining(frame repcore.Frame, order *pendingOrder) {
+	order.started = true
+	order.doneFrame = frame + order.buildFrames
+	if order.unitID == s.config.workerUnitID {
+		s.schedule(scheduledEvent{frame: order.doneFrame, kind: "worker", unitID: order.unitID, producer: order.producer, order: order.id})
+	} else if order.producer != nil {
+		s.schedule(scheduledEvent{frame: order.doneFrame, kind: "army", unitID: order.unitID, producer: order.producer, order: order.id})
+	}
+	if order.supplyHalf > 0 {
+		s.schedule(scheduledEvent{frame: order.doneFrame, kind: "supply-used", unitID: order.unitID, order: order.id, supplyHalf: order.supplyHalf})
+	}
+	if order.unitID == unitIDOverlord {
+		s.schedule(scheduledEvent{frame: order.doneFrame, kind: "supply", unitID: order.unitID, order: order.id})
+	}
+}
+
+// finishTraining takes the finished unit off the queue and starts the next.
+func (s *replayState) finishTraining(frame repcore.Frame, producer *productionBuilding) {
+	if producer == nil || !producer.busy() {
+		return
+	}
+	producer.queue = producer.queue[1:]
+	if producer.busy() {
+		s.startTraining(frame, producer.queue[0])
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"github.com/icza/screp/rep/repcmd"
+	"github.com/icza/screp/rep/repcore"
+)
+
+const (
+	startingMinerals = 50
+
+	// Gather rates are per worker per frame at Fastest speed: roughly 43
+	// minerals a minute on a close patch and 115 gas a minute per geyser
+	// with three workers.
+	mineralsPerWorkerFrame = 0.03
+	gasPerWorkerFrame      = 0.027
+
+	fullRateMinersPerBase = 16
+	halfRateMinersPerBase = 8
+	workersPerGeyser      = 3
+
+	floatGreatThreshold = 300.0
+	floatSolidThreshold = 600.0
+)
+
+type resourceCost struct {
+	minerals int
+	gas      int
+}
+
+// upgradeCost is the cost of the first level of an upgrade and how much each
+// further level adds.
+type upgradeCost struct {
+	base     resourceCost
+	perLevel resourceCost
+}
+
+var refineryUnitIDs = map[uint16]bool{
+	repcmd.UnitIDRefinery:    true,
+	repcmd.UnitIDExtractor:   true,
+	repcmd.UnitIDAssimilator: true,
+}
+
+var unitCosts = map[uint16]resourceCost{
+	unitIDMarine:        {50, 0},
+	unitIDGhost:         {25, 75},
+	unitIDVulture:       {75, 0},
+	unitIDGoliath:       {100, 50},
+	unitIDSiegeTankTM:   {150, 100},
+	unitIDSCV:           {50, 0},
+	unitIDWraith:        {150, 100},
+	unitIDScienceVessel: {100, 225},
+	unitIDDropship:      {100, 100},
+	unitIDBattlecruiser: {400, 300},
+	unitIDFirebat:       {50, 25},
+	unitIDMedic:         {50, 25},
+	unitIDValkyrie:      {250, 125},
+	unitIDDrone:         {50, 0},
+	unitIDZergling:      {50, 0},
+	unitIDOverlord:      {100, 0},
+	unitIDHydralisk:     {75, 25},
+	unitIDMutalisk:      {100, 100},
+	unitIDScourge:       {25, 75},
+	unitIDUltralisk:     {200, 200},
+	unitIDDefiler:       {50, 150},
+	unitIDQueen:         {100, 100},
+	unitIDLurker:        {50, 100},
+	unitIDGuardian:      {50, 100},
+	unitIDDevourer:      {150, 50},
+	unitIDProbe:         {50, 0},
+	unitIDZealot:        {100, 0},
+	unitIDDragoon:       {125, 50},
+	unitIDHighTemplar:   {50, 150},
+	unitIDDarkTemplar:   {125, 100},
+	unitIDShuttle:       {200, 0},
+	unitIDScout:         {275, 125},
+	unitIDArbiter:       {100, 350},
+	unitIDCarrier:       {350, 250},
+	unitIDReaver:        {200, 100},
+	unitIDObserver:      {25, 75},
+	unitIDCorsair:       {150, 100},
+
+	repcmd.UnitIDCommandCenter:   {400, 0},
+	repcmd.UnitIDComSat:          {50, 50},
+	repcmd.UnitIDNuclearSilo:     {100, 100},
+	repcmd.UnitIDSupplyDepot:     {100, 0},
+	repcmd.UnitIDRefinery:        {100, 0},
+	repcmd.UnitIDBarracks:        {150, 0},
+	repcmd.UnitIDAcademy:         {150, 0},
+	repcmd.UnitIDFactory:         {200, 100},
+	repcmd.UnitIDStarport:        {150, 100},
+	repcmd.UnitIDControlTower:    {50, 50},
+	repcmd.UnitIDScienceFacility: {100, 150},
+	repcmd.UnitIDCovertOps:       {50, 50},
+	repcmd.UnitIDPhysicsLab:      {50, 50},
+	repcmd.UnitIDMachineShop:     {50, 50},
+	repcmd.UnitIDEngineeringBay:  {125, 0},
+	repcmd.UnitIDArmory:          {100, 50},
+	repcmd.UnitIDMissileTurret:   {75, 0},
+	repcmd.UnitIDBunker:          {100, 0},
+
+	repcmd.UnitIDHatchery:         {300, 0},
+	repcmd.UnitIDLair:             {150, 100},
+	repcmd.UnitIDHive:             {200, 150},
+	repcmd.UnitIDNydusCanal:       {150, 0},
+	repcmd.UnitIDHydraliskDen:     {100, 50},
+	repcmd.UnitIDDefilerMound:     {100, 100},
+	repcmd.UnitIDGreaterSpire:     {100, 150},
+	repcmd.UnitIDQueensNest:       {150, 100},
+	repcmd.UnitIDEvolutionChamber: {75, 0},
+	repcmd.UnitIDUltraliskCavern:  {150, 200},
+	repcmd.UnitIDSpire:            {200, 150},
+	repcmd.UnitIDSpawningPool:     {200, 0},
+	repcmd.UnitIDCreepColony:      {75, 0},
+	repcmd.UnitIDSporeColony:      {50, 0},
+	repcmd.UnitIDSunkenColony:     {50, 0},
+	repcmd.UnitIDExtractor:        {50, 0},
+
+	repcmd.UnitIDNexus:              {400, 0},
+	repcmd.UnitIDRoboticsFacility:   {200, 200},
+	repcmd.UnitIDPylon:              {100, 0},
+	repcmd.UnitIDAssimilator:        {100, 0},
+	repcmd.UnitIDObservatory:        {50, 100},
+	repcmd.UnitIDGateway:            {150, 0},
+	repcmd.UnitIDPhotonCannon:       {150, 0},
+	repcmd.UnitIDCitadelOfAdun:      {150, 100},
+	repcmd.UnitIDCyberneticsCore:    {200, 0},
+	repcmd.UnitIDTemplarArchives:    {150, 200},
+	repcmd.UnitIDForge:              {150, 0},
+	repcmd.UnitIDStargate:           {150, 150},
+	repcmd.UnitIDFleetBeacon:        {300, 200},
+	repcmd.UnitIDArbiterTribunal:    {200, 150},
+	repcmd.UnitIDRoboticsSupportBay: {150, 100},
+	repcmd.UnitIDShieldBattery:      {100, 0},
+}
+
+var techCosts = map[byte]resourceCost{
+	0x00: {100, 100}, // Stim Packs
+	0x01: {200, 200}, // Lockdown
+	0x02: {200, 200}, // EMP Shockwave
+	0x03: {100, 100}, // Spider Mines
+	0x05: {150, 150}, // Tank Siege Mode
+	0x07: {200, 200}, // Irradiate
+	0x08: {100, 100}, // Yamato Gun
+	0x09: {150, 150}, // Cloaking Field
+	0x0a: {100, 100}, // Personnel Cloaking
+	0x0b: {100, 100}, // Burrowing
+	0x0d: {100, 100}, // Spawn Broodlings
+	0x0f: {200, 200}, // Plague
+	0x10: {100, 100}, // Consume
+	0x11: {100, 100}, // Ensnare
+	0x13: {200, 200}, // Psionic Storm
+	0x14: {150, 150}, // Hallucination
+	0x15: {150, 150}, // Recall
+	0x16: {150, 150}, // Stasis Field
+	0x18: {100, 100}, // Restoration
+	0x19: {200, 200}, // Disruption Web
+	0x1b: {200, 200}, // Mind Control
+	0x1e: {100, 100}, // Optical Flare
+	0x1f: {100, 100}, // Maelstrom
+	0x20: {200, 200}, // Lurker Aspect
+}
+
+var upgradeCosts = map[byte]upgradeCost{
+	0x00: {resourceCost{100, 100}, resourceCost{75, 75}},   // Terran Infantry Armor
+	0x01: {resourceCost{100, 100}, resourceCost{75, 75}},   // Terran Vehicle Plating
+	0x02: {resourceCost{150, 150}, resourceCost{75, 75}},   // Terran Ship Plating
+	0x03: {resourceCost{150, 150}, resourceCost{75, 75}},   // Zerg Carapace
+	0x04: {resourceCost{150, 150}, resourceCost{75, 75}},   // Zerg Flyer Carapace
+	0x05: {resourceCost{100, 100}, resourceCost{75, 75}},   // Protoss Ground Armor
+	0x06: {resourceCost{150, 150}, resourceCost{75, 75}},   // Protoss Air Armor
+	0x07: {resourceCost{100, 100}, resourceCost{75, 75}},   // Terran Infantry Weapons
+	0x08: {resourceCost{100, 100}, resourceCost{75, 75}},   // Terran Vehicle Weapons
+	0x09: {resourceCost{100, 100}, resourceCost{50, 50}},   // Terran Ship Weapons
+	0x0A: {resourceCost{100, 100}, resourceCost{50, 50}},   // Zerg Melee Attacks
+	0x0B: {resourceCost{100, 100}, resourceCost{50, 50}},   // Zerg Missile Attacks
+	0x0C: {resourceCost{100, 100}, resourceCost{75, 75}},   // Zerg Flyer Attacks
+	0x0D: {resourceCost{100, 100}, resourceCost{50, 50}},   // Protoss Ground Weapons
+	0x0E: {resourceCost{100, 100}, resourceCost{75, 75}},   // Protoss Air Weapons
+	0x0F: {resourceCost{200, 200}, resourceCost{100, 100}}, // Protoss Plasma Shields
+	0x10: {base: resourceCost{150, 150}},                   // U-238 Shells
+	0x11: {base: resourceCost{100, 100}},                   // Ion Thrusters
+	0x13: {base: resourceCost{150, 150}},                   // Titan Reactor
+	0x14: {base: resourceCost{100, 100}},                   // Ocular Implants
+	0x15: {base: resourceCost{150, 150}},                   // Moebius Reactor
+	0x16: {base: resourceCost{200, 200}},                   // Apollo Reactor
+	0x17: {base: resourceCost{150, 150}},                   // Colossus Reactor
+	0x18: {base: resourceCost{200, 200}},                   // Ventral Sacs
+	0x19: {base: resourceCost{150, 150}},                   // Antennae
+	0x1A: {base: resourceCost{150, 150}},                   // Pneumatized Carapace
+	0x1B: {base: resourceCost{100, 100}},                   // Metabolic Boost
+	0x1C: {base: resourceCost{200, 200}},                   // Adrenal Glands
+	0x1D: {base: resourceCost{150, 150}},                   // Muscular Augments
+	0x1E: {base: resourceCost{150, 150}},                   // Grooved Spines
+	0x1F: {base: resourceCost{150, 150}},                   // Gamete Meiosis
+	0x20: {base: resourceCost{150, 150}},                   // Metasynaptic Node
+	0x21: {base: resourceCost{150, 150}},                   // Singularity Charge
+	0x22: {base: resourceCost{150, 150}},                   // Leg Enhancements
+	0x23: {base: resourceCost{200, 200}},                   // Scarab Damage
+	0x24: {base: resourceCost{200, 200}},                   // Reaver Capacity
+	0x25: {base: resourceCost{200, 200}},                   // Gravitic Drive
+	0x26: {base: resourceCost{150, 150}},                   // Sensor Array
+	0x27: {base: resourceCost{150, 150}},                   // Gravitic Boosters
+	0x28: {base: resourceCost{150, 150}},                   // Khaydarin Amulet
+	0x29: {base: resourceCost{100, 100}},                   // Apial Sensors
+	0x2A: {base: resourceCost{200, 200}},                   // Gravitic Thrusters
+	0x2B: {base: resourceCost{100, 100}},                   // Carrier Capacity
+	0x2C: {base: resourceCost{150, 150}},                   // Khaydarin Core
+	0x2F: {base: resourceCost{100, 100}},                   // Argus Jewel
+	0x31: {base: resourceCost{150, 150}},                   // Argus Talisman
+	0x33: {base: resourceCost{150, 150}},                   // Caduceus Reactor
+	0x34: {base: resourceCost{150, 150}},                   // Chitinous Plating
+	0x35: {base: resourceCost{200, 200}},                   // Anabolic Synthesis
+	0x36: {base: resourceCost{100, 100}},                   // Charon Boosters
+}
+
+// upgradeLevelCost returns the cost of an upgrade level, starting at 1.
+func upgradeLevelCost(upgradeID byte, level int) resourceCost {
+	cost := upgradeCosts[upgradeID]
+	if level < 1 {
+		level = 1
+	}
+	return resourceCost{
+		minerals: cost.base.minerals + cost.perLevel.minerals*(level-1),
+		gas:      cost.base.gas + cost.perLevel.gas*(level-1),
+	}
+}
+
+// techResearchFrames are the research times of the techs in techCosts.
+var techResearchFrames = map[byte]repcore.Frame{
+	0x00: 1200, // Stim Packs
+	0x01: 1500, // Lockdown
+	0x02: 1800, // EMP Shockwave
+	0x03: 1200, // Spider Mines
+	0x05: 1200, // Tank Siege Mode
+	0x07: 1200, // Irradiate
+	0x08: 1800, // Yamato Gun
+	0x09: 1500, // Cloaking Field
+	0x0a: 1200, // Personnel Cloaking
+	0x0b: 1200, // Burrowing
+	0x0d: 1200, // Spawn Broodlings
+	0x0f: 1500, // Plague
+	0x10: 1500, // Consume
+	0x11: 1200, // Ensnare
+	0x13: 1800, // Psionic Storm
+	0x14: 1200, // Hallucination
+	0x15: 1800, // Recall
+	0x16: 1500, // Stasis Field
+	0x18: 1200, // Restoration
+	0x19: 1200, // Disruption Web
+	0x1b: 1800, // Mind Control
+	0x1e: 1800, // Optical Flare
+	0x1f: 1500, // Maelstrom
+	0x20: 1800, // Lurker Aspect
+}
+
+// upgradeResearchFrames are the first-level research times of the upgrades
+// in upgradeCosts. Each further level of a weapon, armor or shield upgrade
+// takes upgradeLevelFrames longer.
+var upgradeResearchFrames = map[byte]repcore.Frame{
+	0x00: 4000, // Terran Infantry Armor
+	0x01: 4000, // Terran Vehicle Plating
+	0x02: 4000, // Terran Ship Plating
+	0x03: 4000, // Zerg Carapace
+	0x04: 4000, // Zerg Flyer Carapace
+	0x05: 4000, // Protoss Ground Armor
+	0x06: 4000, // Protoss Air Armor
+	0x07: 4000, // Terran Infantry Weapons
+	0x08: 4000, // Terran Vehicle Weapons
+	0x09: 4000, // Terran Ship Weapons
+	0x0A: 4000, // Zerg Melee Attacks
+	0x0B: 4000, // Zerg Missile Attacks
+	0x0C: 4000, // Zerg Flyer Attacks
+	0x0D: 4000, // Protoss Ground Weapons
+	0x0E: 4000, // Protoss Air Weapons
+	0x0F: 4000, // Protoss Plasma Shields
+	0x10: 1500, // U-238 Shells
+	0x11: 1500, // Ion Thrusters
+	0x13: 2500, // Titan Reactor
+	0x14: 2500, // Ocular Implants
+	0x15: 2500, // Moebius Reactor
+	0x16: 2500, // Apollo Reactor
+	0x17: 2500, // Colossus Reactor
+	0x18: 2400, // Ventral Sacs
+	0x19: 2000, // Antennae
+	0x1A: 2000, // Pneumatized Carapace
+	0x1B: 1500, // Metabolic Boost
+	0x1C: 1500, // Adrenal Glands
+	0x1D: 1500, // Muscular Augments
+	0x1E: 1500, // Grooved Spines
+	0x1F: 2500, // Gamete Meiosis
+	0x20: 2500, // Metasynaptic Node
+	0x21: 2500, // Singularity Charge
+	0x22: 2000, // Leg Enhancements
+	0x23: 2500, // Scarab Damage
+	0x24: 2500, // Reaver Capacity
+	0x25: 2500, // Gravitic Drive
+	0x26: 2500, // Sensor Array
+	0x27: 2000, // Gravitic Boosters
+	0x28: 2500, // Khaydarin Amulet
+	0x29: 2500, // Apial Sensors
+	0x2A: 2500, // Gravitic Thrusters
+	0x2B: 1500, // Carrier Capacity
+	0x2C: 2500, // Khaydarin Core
+	0x2F: 2500, // Argus Jewel
+	0x31: 2500, // Argus Talisman
+	0x33: 2500, // Caduceus Reactor
+	0x34: 2000, // Chitinous Plating
+	0x35: 2000, // Anabolic Synthesis
+	0x36: 2000, // Charon Boosters
+}
+
+const upgradeLevelFrames repcore.Frame = 480
+
+// upgradeLevelResearchFrames returns the research time of an upgrade level,
+// starting at 1. Only upgrades with more than one level get slower.
+func upgradeLevelResearchFrames(upgradeID byte, level int) repcore.Frame {
+	frames := upgradeResearchFrames[upgradeID]
+	if level > 1 && upgradeCosts[upgradeID].perLevel != (resourceCost{}) {
+		frames += upgradeLevelFrames * repcore.Frame(level-1)
+	}
+	return frames
+}
+
+// resourceBank estimates the minerals and gas a player has on hand.
+type resourceBank struct {
+	minerals float64
+	gas      float64
+}
+
+// gather adds one frame of income. Mining saturates per base: the first 16
+// workers mine at full rate, the next 8 at half rate, and the rest add
+// nothing. Each finished refinery takes three workers off minerals.
+func (b *resourceBank) gather(workers, bases, refineries int) {
+	gasWorkers := refineries * workersPerGeyser
+	if gasWorkers > workers {
+		gasWorkers = workers
+	}
+	miners := float64(workers - gasWorkers)
+	fullRate := float64(bases * fullRateMinersPerBase)
+	halfRate := float64(bases * halfRateMinersPerBase)
+
+	effective := miners
+	if miners > fullRate {
+		effective = fullRate + (miners-fullRate)/2
+		if miners > fullRate+halfRate {
+			effective = fullRate + halfRate/2
+		}
+	}
+
+	b.minerals += effective * mineralsPerWorkerFrame
+	b.gas += float64(gasWorkers) * gasPerWorkerFrame
+}
+
+// spend deducts a cost. Replays also record orders the player could not
+// afford, and the income estimate can lag, so the balance is clamped at zero.
+func (b *resourceBank) spend(cost resourceCost) {
+	b.minerals -= float64(cost.minerals)
+	if b.minerals < 0 {
+		b.minerals = 0
+	}
+	b.gas -= float64(cost.gas)
+	if b.gas < 0 {
+		b.gas = 0
+	}
+}
+
+func (b *resourceBank) refund(cost resourceCost) {
+	b.minerals += float64(cost.minerals)
+	b.gas += float64(cost.gas)
+}
+
+func (b *resourceBank) unspent() float64 {
+	return b.minerals + b.gas
+}
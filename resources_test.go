@@ -0,0 +1,50 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestResourceBankGatherSaturates(t *testing.T) {
+	var oneBase, twoBases resourceBank
+	for i := 0; i < 1000; i++ {
+		oneBase.gather(40, 1, 0)
+		twoBases.gather(40, 2, 0)
+	}
+
+	// 16 full-rate and 8 half-rate miners on one base; all 40 mine on two.
+	if got, want := oneBase.minerals, 20*mineralsPerWorkerFrame*1000; math.Abs(got-want) > 1e-6 {
+		t.Fatalf("expected saturated income %.1f, got %.1f", want, got)
+	}
+	if twoBases.minerals <= oneBase.minerals {
+		t.Fatalf("expected a second base to raise income, got %.1f vs %.1f", twoBases.minerals, oneBase.minerals)
+	}
+}
+
+func TestResourceBankGasWorkers(t *testing.T) {
+	var bank resourceBank
+	bank.gather(10, 1, 1)
+
+	if got, want := bank.gas, 3*gasPerWorkerFrame; math.Abs(got-want) > 1e-9 {
+		t.Fatalf("expected gas from three workers, got %f", got)
+	}
+	if got, want := bank.minerals, 7*mineralsPerWorkerFrame; math.Abs(got-want) > 1e-9 {
+		t.Fatalf("expected minerals from the remaining seven workers, got %f", got)
+	}
+}
+
+func TestResourceBankSpendClampsAtZero(t *testing.T) {
+	bank := resourceBank{minerals: 75, gas: 20}
+	bank.spend(resourceCost{minerals: 100, gas: 10})
+
+	if bank.minerals != 0 || bank.gas != 10 {
+		t.Fatalf("expected clamped minerals and 10 gas, got %+v", bank)
+	}
+}
+
+func TestUpgradeLevelCost(t *testing.T) {
+	// Terran Infantry Weapons: 100/100 plus 75/75 per level.
+	if got := upgradeLevelCost(0x07, 3); got != (resourceCost{250, 250}) {
+		t.Fatalf("unexpected level 3 cost: %+v", got)
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	screp "github.com/icza/screp/rep"
+	"github.com/icza/screp/rep/repcmd"
+	"github.com/icza/screp/rep/repcore"
+	"github.com/icza/screp/repparser"
+)
+
+func TestWriteReplayFileParses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "game.rep")
+	writeReplayFile(t, path, corpusReplay(0, "alpha"))
+
+	rep, err := repparser.ParseFileConfig(path, repparser.Config{Commands: true})
+	if err != nil {
+		t.Fatalf("unexpected parse error: %v", err)
+	}
+	if rep.Header.Map != "Fighting Spirit 1.3" || len(rep.Header.Players) != 2 || rep.Header.Players[0].Name != "alpha" {
+		t.Fatalf("unexpected header: %+v", rep.Header)
+	}
+	if rep.Commands == nil || len(rep.Commands.Cmds) == 0 {
+		t.Fatalf("expected commands to be parsed back")
+	}
+}
+
+func TestTwoPhaseScanMatchesFullParse(t *testing.T) {
+	repFiles := writeCorpus(t, t.TempDir(), 24, 3)
+	garbage := filepath.Join(filepath.Dir(repFiles[0]), "broken.rep")
+	if err := os.WriteFile(garbage, []byte("not a replay"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	repFiles = append(repFiles, garbage)
+	target := ScanTarget{DisplayLabel: "alpha", Names: []string{"alpha"}, Workers: 4}
+
+	summary := analyzeReplayFiles(context.Background(), target, repFiles, nil, nil)
+	full := fullParseSummary(t, target, repFiles)
+
+	if !reflect.DeepEqual(summary, full) {
+		t.Fatalf("two-phase scan differs from parsing every replay:\ntwo-phase: %+v\nfull:      %+v", summary, full)
+	}
+	if summary.MatchedReplays != 8 || summary.SkippedReplays != 1 {
+		t.Fatalf("expected 8 matched and 1 skipped replay, got %+v", summary)
+	}
+}
+
+func TestTwoPhaseScanUsesCacheAndFilter(t *testing.T) {
+	dir := t.TempDir()
+	repFiles := writeCorpus(t, dir, 12, 2)
+	target := ScanTarget{DisplayLabel: "alpha", Names: []string{"alpha"}}
+	cache := loadReplayCache(filepath.Join(dir, "replay-cache.json"))
+
+	first := analyzeReplayFiles(context.Background(), target, repFiles, cache, nil)
+	second := analyzeReplayFiles(context.Background(), target, repFiles, cache, nil)
+
+	if first.CachedReplays != 0 || second.CachedReplays != first.MatchedReplays || second.MatchedReplays != first.MatchedReplays {
+		t.Fatalf("expected the rescan to come from the cache, got %d then %d cached of %d matched",
+			first.CachedReplays, second.CachedReplays, first.MatchedReplays)
+	}
+
+	target.Filter = ScanFilter{MinDuration: 11 * time.Minute}
+	filtered := analyzeReplayFiles(context.Background(), target, repFiles, cache, nil)
+	if filtered.FilteredReplays == 0 || filtered.MatchedReplays >= first.MatchedReplays || filtered.CachedReplays != filtered.MatchedReplays {
+		t.Fatalf("expected the duration filter to apply on the header pass in front of the cache, got %d matched, %d filtered, %d cached",
+			filtered.MatchedReplays, filtered.FilteredReplays, filtered.CachedReplays)
+	}
+}
+
+func TestTwoPhaseScanFiltersOnlyTargetReplays(t *testing.T) {
+	repFiles := writeCorpus(t, t.TempDir(), 12, 2)
+	target := ScanTarget{
+		DisplayLabel: "alpha",
+		Names:        []string{"alpha"},
+		Filter:       ScanFilter{MinDuration: 11 * time.Minute},
+	}
+
+	summary := analyzeReplayFiles(context.Background(), target, repFiles, nil, nil)
+
+	// Games 0, 5 and 10 last 10 minutes; game 5 isn't alpha's.
+	if summary.FilteredReplays != 2 || summary.MatchedReplays != 4 {
+		t.Fatalf("expected 2 of alpha's 6 games filtered, got %d filtered and %d matched",
+			summary.FilteredReplays, summary.MatchedReplays)
+	}
+}
+
+func TestScanMacroStatsReadsReplayRoots(t *testing.T) {
+	dir := t.TempDir()
+	writeCorpus(t, dir, 6, 2)
+	var progress []float64
+
+	summary, err := scanMacroStats(context.Background(), ScanTarget{
+		DisplayLabel: "alpha",
+		Names:        []string{"alpha"},
+		Roots:        []ReplayRoot{{Path: dir}},
+	}, func(p float64) { progress = append(progress, p) })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if summary.MatchedReplays < 3 || summary.Incomplete {
+		t.Fatalf("expected the corpus replays to be matched, got %+v", summary)
+	}
+	if progress[len(progress)-1] != 1 {
+		t.Fatalf("expected progress to end at 1, got %v", progress)
+	}
+}
+
+// BenchmarkScanCorpus compares parsing every replay fully, as the scan did
+// before the header pass, with the two-phase scan. A quarter of the corpus
+// has the target, like an AutoSave folder next to downloaded replays.
+func BenchmarkScanCorpus(b *testing.B) {
+	repFiles := writeCorpus(b, b.TempDir(), 120, 4)
+	target := ScanTarget{DisplayLabel: "alpha", Names: []string{"alpha"}, Workers: 1}
+
+	b.Run("full-parse", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			fullParseSummary(b, target, repFiles)
+		}
+	})
+	b.Run("two-phase", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			analyzeReplayFiles(context.Background(), target, repFiles, nil, nil)
+		}
+	})
+}
+
+// fullParseSummary parses and analyzes every replay, without the header
+// pass.
+func fullParseSummary(tb testing.TB, target ScanTarget, repFiles []string) *MacroSummary {
+	tb.Helper()
+	analyses := make([]replayAnalysis, len(repFiles))
+	for i, repFile := range repFiles {
+		analysis, err := analyzeReplayFile(context.Background(), repFile, target)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		analyses[i] = analysis
+	}
+	summary := summarizeReplayAnalyses(target, analyses)
+	summary.ScannedReplays = len(repFiles)
+	return summary
+}
+
+// writeCorpus writes count generated replays to dir; every targetEvery-th
+// one has alpha as a player.
+func writeCorpus(tb testing.TB, dir string, count, targetEvery int) []string {
+	tb.Helper()
+	repFiles := make([]string, count)
+	for i := range repFiles {
+		name := fmt.Sprintf("pro%d", i%5)
+		if i%targetEvery == 0 {
+			name = "alpha"
+		}
+		repFiles[i] = filepath.Join(dir, fmt.Sprintf("game-%03d.rep", i))
+		writeReplayFile(tb, repFiles[i], corpusReplay(i, name))
+	}
+	return repFiles
+}
+
+// corpusReplay is a 10 to 14 minute TvZ where both players macro at about
+// 150 APM.
+func corpusReplay(index int, name string) *screp.Replay {
+	seconds := 600 + index%5*60
+	var cmds []repcmd.Cmd
+	for _, player := range []struct {
+		id     byte
+		worker uint16
+		depot  uint16
+	}{{1, unitIDSCV, repcmd.UnitIDSupplyDepot}, {2, unitIDDrone, repcmd.UnitIDSpawningPool}} {
+		for frame := repcore.Frame(24 + index%7); frame < secondFrame(seconds); frame += 10 {
+			base := &repcmd.Base{Frame: frame, PlayerID: player.id}
+			switch frame / 10 % 4 {
+			case 0:
+				base.Type = repcmd.TypeSelect
+				cmds = append(cmds, &repcmd.SelectCmd{Base: base, UnitTags: []repcmd.UnitTag{repcmd.UnitTag(frame % 40)}})
+			case 1:
+				base.Type = repcmd.TypeHotkey
+				cmds = append(cmds, &repcmd.HotkeyCmd{Base: base, HotkeyType: repcmd.HotkeyTypeByID(1), Group: byte(frame % 3)})
+			case 2:
+				base.Type = repcmd.TypeTrain
+				cmds = append(cmds, &repcmd.TrainCmd{Base: base, Unit: repcmd.UnitByID(player.worker)})
+			default:
+				base.Type = repcmd.TypeBuild
+				cmds = append(cmds, &repcmd.BuildCmd{Base: base, Unit: repcmd.UnitByID(player.depot)})
+			}
+		}
+	}
+	sort.SliceStable(cmds, func(i, j int) bool { return cmds[i].BaseCmd().Frame < cmds[j].BaseCmd().Frame })
+
+	return &screp.Replay{
+		Header: &screp.Header{
+			Frames:    secondFrame(seconds),
+			StartTime: time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC).AddDate(0, 0, index),
+			Type:      repcore.GameTypeMelee,
+			Map:       "Fighting Spirit 1.3",
+			Players: []*screp.Player{
+				{ID: 1, Name: name, Race: repcore.RaceTerran, Type: repcore.PlayerTypeHuman, Team: 1},
+				{ID: 2, Name: fmt.Sprintf("zerg%d", index%3), Race: repcore.RaceZerg, Type: repcore.PlayerTypeHuman, Team: 2},
+			},
+		},
+		Commands: &screp.Commands{Cmds: cmds},
+	}
+}
+
+// writeReplayFile encodes rep as a 1.18-1.20 replay: the replay ID, header,
+// commands, empty map data and player names sections, each zlib compressed.
+// Only the header fields and command types the analyzer reads are written.
+func writeReplayFile(tb testing.TB, path string, rep *screp.Replay) {
+	tb.Helper()
+	le := binary.LittleEndian
+
+	header := make([]byte, 0x279)
+	header[0x00] = 1 // Brood War
+	le.PutUint32(header[0x01:], uint32(rep.Header.Frames))
+	le.PutUint32(header[0x08:], uint32(rep.Header.StartTime.Unix()))
+	le.PutUint16(header[0x3c:], rep.Header.Type.ID)
+	copy(header[0x61:0x61+25], rep.Header.Map)
+	for i, player := range rep.Header.Players {
+		slot := header[0xa1+i*36:]
+		le.PutUint16(slot, uint16(i))
+		slot[4] = player.ID
+		slot[8] = byte(player.Type.ID)
+		slot[9] = byte(player.Race.ID)
+		slot[10] = player.Team
+		copy(slot[11:11+24], player.Name)
+	}
+
+	var commands bytes.Buffer
+	var block []byte
+	blockFrame := repcore.Frame(0)
+	flush := func() {
+		if len(block) > 0 {
+			binary.Write(&commands, le, uint32(blockFrame))
+			commands.WriteByte(byte(len(block)))
+			commands.Write(block)
+			block = block[:0]
+		}
+	}
+	for _, cmd := range rep.Commands.Cmds {
+		base := cmd.BaseCmd()
+		encoded := []byte{base.PlayerID, base.Type.ID}
+		switch cmd := cmd.(type) {
+		case *repcmd.TrainCmd:
+			encoded = le.AppendUint16(encoded, cmd.Unit.ID)
+		case *repcmd.BuildCmd:
+			encoded = append(encoded, repcmd.OrderIDPlaceProtossBuilding)
+			encoded = le.AppendUint16(encoded, cmd.Pos.X)
+			encoded = le.AppendUint16(encoded, cmd.Pos.Y)
+			encoded = le.AppendUint16(encoded, cmd.Unit.ID)
+		case *repcmd.SelectCmd:
+			encoded = append(encoded, byte(len(cmd.UnitTags)))
+			for _, tag := range cmd.UnitTags {
+				encoded = le.AppendUint16(encoded, uint16(tag))
+			}
+		case *repcmd.HotkeyCmd:
+			encoded = append(encoded, cmd.HotkeyType.ID, cmd.Group)
+		default:
+			tb.Fatalf("writeReplayFile can't encode %s", base.Type.Name)
+		}
+		if base.Frame != blockFrame || len(block)+len(encoded) > 255 {
+			flush()
+			blockFrame = base.Frame
+		}
+		block = append(block, encoded...)
+	}
+	flush()
+
+	var out bytes.Buffer
+	writeReplaySection(&out, []byte("reRS"))
+	writeReplaySection(&out, header)
+	writeReplaySection(&out, le.AppendUint32(nil, uint32(commands.Len())))
+	if commands.Len() > 0 {
+		writeReplaySection(&out, commands.Bytes())
+	}
+	writeReplaySection(&out, le.AppendUint32(nil, 0)) // no map data
+	writeReplaySection(&out, make([]byte, 0x300))
+
+	if err := os.WriteFile(path, out.Bytes(), 0o644); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// writeReplaySection writes one section as a single chunk. Chunks of up to 4
+// bytes are stored raw, like the game does.
+func writeReplaySection(out *bytes.Buffer, data []byte) {
+	le := binary.LittleEndian
+	chunk := data
+	if len(data) > 4 {
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		zw.Write(data)
+		zw.Close()
+		chunk = compressed.Bytes()
+	}
+	out.Write(le.AppendUint32(nil, 0)) // checksum, not verified by the parser
+	out.Write(le.AppendUint32(nil, 1)) // chunk count
+	out.Write(le.AppendUint32(nil, uint32(len(chunk))))
+	out.Write(chunk)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"github.com/icza/screp/rep/repcmd"
+)
+
+// selectionTracker follows a player's current selection and hotkey groups so
+// production orders can be tied to the building they were issued to.
+type selectionTracker struct {
+	current []repcmd.UnitTag
+	groups  map[byte][]repcmd.UnitTag
+}
+
+func newSelectionTracker() *selectionTracker {
+	return &selectionTracker{groups: map[byte][]repcmd.UnitTag{}}
+}
+
+// handle updates the selection from Select, Shift-Select, Deselect and Hotkey
+// commands. Other commands are ignored.
+func (t *selectionTracker) handle(cmd repcmd.Cmd) {
+	switch cmd := cmd.(type) {
+	case *repcmd.SelectCmd:
+		switch cmd.Type.ID {
+		case repcmd.TypeIDSelect, repcmd.TypeIDSelect121:
+			t.current = copyTags(cmd.UnitTags)
+		case repcmd.TypeIDSelectAdd, repcmd.TypeIDSelectAdd121:
+			t.current = addTags(t.current, cmd.UnitTags)
+		case repcmd.TypeIDSelectRemove, repcmd.TypeIDSelectRemove121:
+			t.current = removeTags(t.current, cmd.UnitTags)
+		}
+	case *repcmd.HotkeyCmd:
+		if cmd.HotkeyType == nil {
+			return
+		}
+		switch cmd.HotkeyType.ID {
+		case repcmd.HotkeyTypeIDAssign:
+			t.groups[cmd.Group] = copyTags(t.current)
+		case repcmd.HotkeyTypeIDSelect:
+			t.current = copyTags(t.groups[cmd.Group])
+		case repcmd.HotkeyTypeIDAdd:
+			t.groups[cmd.Group] = addTags(t.groups[cmd.Group], t.current)
+		}
+	}
+}
+
+// producer returns the unit a production order goes to. Brood War only lets
+// one building be selected at a time, so that is the first selected unit.
+func (t *selectionTracker) producer() (repcmd.UnitTag, bool) {
+	if t == nil || len(t.current) == 0 || !t.current[0].Valid() {
+		return 0, false
+	}
+	return t.current[0], true
+}
+
+func copyTags(tags []repcmd.UnitTag) []repcmd.UnitTag {
+	return append([]repcmd.UnitTag(nil), tags...)
+}
+
+func addTags(dst, tags []repcmd.UnitTag) []repcmd.UnitTag {
+	result := copyTags(dst)
+	for _, tag := range tags {
+		if !containsTag(result, tag) {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+func removeTags(dst, tags []repcmd.UnitTag) []repcmd.UnitTag {
+	result := make([]repcmd.UnitTag, 0, len(dst))
+	for _, tag := range dst {
+		if !containsTag(tags, tag) {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+func containsTag(tags []repcmd.UnitTag, target repcmd.UnitTag) bool {
+	for _, tag := range tags {
+		if tag == target {
+			return true
+		}
+	}
+	return false
+}
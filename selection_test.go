@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/icza/screp/rep/repcmd"
+)
+
+func TestSelectionTrackerHotkeys(t *testing.T) {
+	tracker := newSelectionTracker()
+	for _, cmd := range []timedCmd{
+		selectUnitsAtFrame(0, 0x0100),
+		hotkeyAtFrame(1, repcmd.HotkeyTypeIDAssign, 4),
+		selectUnitsAtFrame(2, 0x0200, 0x0300),
+		hotkeyAtFrame(3, repcmd.HotkeyTypeIDAdd, 4),
+		{frame: 4, kind: repcmd.TypeIDSelectRemove, tags: []repcmd.UnitTag{0x0200}},
+	} {
+		tracker.handle(cmd.toCmd(1))
+	}
+
+	if tag, ok := tracker.producer(); !ok || tag != 0x0300 {
+		t.Fatalf("expected 0x0300 after deselect, got %x (%t)", tag, ok)
+	}
+
+	tracker.handle(hotkeyAtFrame(5, repcmd.HotkeyTypeIDSelect, 4).toCmd(1))
+	if len(tracker.current) != 3 {
+		t.Fatalf("expected hotkey group with 3 units, got %x", tracker.current)
+	}
+	if tag, ok := tracker.producer(); !ok || tag != 0x0100 {
+		t.Fatalf("expected 0x0100 from hotkey group, got %x (%t)", tag, ok)
+	}
+}
+
+func TestSelectionTrackerEmptyGroup(t *testing.T) {
+	tracker := newSelectionTracker()
+	tracker.handle(selectUnitsAtFrame(0, 0x0100).toCmd(1))
+	tracker.handle(hotkeyAtFrame(1, repcmd.HotkeyTypeIDSelect, 7).toCmd(1))
+
+	if _, ok := tracker.producer(); ok {
+		t.Fatalf("expected no producer after recalling an empty group")
+	}
+}
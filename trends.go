@@ -0,0 +1,149 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// Trend periods replays can be grouped by.
+const (
+	trendPeriodDay   = "day"
+	trendPeriodWeek  = "week"
+	trendPeriodMonth = "month"
+)
+
+const (
+	// trendRollingPeriods is how many periods the rolling average spans.
+	trendRollingPeriods = 4
+	// trendChangeDays is the window the summary compares against the one
+	// before it.
+	trendChangeDays = 30
+)
+
+var trendPeriods = []string{trendPeriodDay, trendPeriodWeek, trendPeriodMonth}
+
+// periodStart returns the start of the day, week (from Monday) or month
+// containing t.
+func periodStart(t time.Time, period string) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	switch period {
+	case trendPeriodWeek:
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case trendPeriodMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	}
+	return day
+}
+
+// buildTrend groups the matched replays with a known start time by local
+// period, oldest first. Periods without games are left out. The rolling averages
+// cover the point and the trendRollingPeriods-1 points before it, weighted
+// by games.
+func buildTrend(results []ReplayMacroResult, period string) TrendSeries {
+	type totals struct {
+		games, supplyBlocked, workerIdle int
+	}
+	// Cached results come back in UTC and fresh ones in local time, so
+	// periods are taken in local time and keyed by instant.
+	buckets := map[int64]*totals{}
+	var starts []time.Time
+	for _, result := range results {
+		if !result.Matched || result.StartTime.IsZero() {
+			continue
+		}
+		start := periodStart(result.StartTime.Local(), period)
+		bucket := buckets[start.Unix()]
+		if bucket == nil {
+			bucket = &totals{}
+			buckets[start.Unix()] = bucket
+			starts = append(starts, start)
+		}
+		bucket.games++
+		bucket.supplyBlocked += result.SupplyBlockedSeconds
+		bucket.workerIdle += result.WorkerIdleSeconds
+	}
+
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+
+	series := TrendSeries{Period: period, Points: make([]TrendPoint, 0, len(starts))}
+	for i, start := range starts {
+		bucket := buckets[start.Unix()]
+		point := TrendPoint{
+			Start:                   start,
+			Games:                   bucket.games,
+			AvgSupplyBlockedSeconds: float64(bucket.supplyBlocked) / float64(bucket.games),
+			AvgWorkerIdleSeconds:    float64(bucket.workerIdle) / float64(bucket.games),
+		}
+
+		var rolling totals
+		for j := i; j >= 0 && j > i-trendRollingPeriods; j-- {
+			previous := buckets[starts[j].Unix()]
+			rolling.games += previous.games
+			rolling.supplyBlocked += previous.supplyBlocked
+			rolling.workerIdle += previous.workerIdle
+		}
+		point.RollingSupplyBlockedSeconds = float64(rolling.supplyBlocked) / float64(rolling.games)
+		point.RollingWorkerIdleSeconds = float64(rolling.workerIdle) / float64(rolling.games)
+		series.Points = append(series.Points, point)
+	}
+	return series
+}
+
+// recentTrendChange compares the last days before the newest replay with the
+// same number of days before that. It returns nil unless both windows have
+// games. Changes are fractions: -0.12 is down 12%.
+func recentTrendChange(results []ReplayMacroResult, days int) *TrendChange {
+	var latest time.Time
+	for _, result := range results {
+		if result.Matched && result.StartTime.After(latest) {
+			latest = result.StartTime
+		}
+	}
+	if latest.IsZero() {
+		return nil
+	}
+
+	recentStart := latest.AddDate(0, 0, -days)
+	previousStart := recentStart.AddDate(0, 0, -days)
+	var recent, previous []ReplayMacroResult
+	for _, result := range results {
+		if !result.Matched || result.StartTime.IsZero() {
+			continue
+		}
+		switch {
+		case result.StartTime.After(recentStart):
+			recent = append(recent, result)
+		case result.StartTime.After(previousStart):
+			previous = append(previous, result)
+		}
+	}
+	if len(recent) == 0 || len(previous) == 0 {
+		return nil
+	}
+
+	recentSupply, recentWorker := averageIdleMetrics(recent)
+	previousSupply, previousWorker := averageIdleMetrics(previous)
+	return &TrendChange{
+		Days:                days,
+		SupplyBlockedChange: relativeChange(previousSupply, recentSupply),
+		WorkerIdleChange:    relativeChange(previousWorker, recentWorker),
+	}
+}
+
+func averageIdleMetrics(results []ReplayMacroResult) (supplyBlocked, workerIdle float64) {
+	for _, result := range results {
+		supplyBlocked += float64(result.SupplyBlockedSeconds)
+		workerIdle += float64(result.WorkerIdleSeconds)
+	}
+	return supplyBlocked / float64(len(results)), workerIdle / float64(len(results))
+}
+
+func relativeChange(before, after float64) float64 {
+	if before == 0 {
+		if after == 0 {
+			return 0
+		}
+		return 1
+	}
+	return (after - before) / before
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPeriodStart(t *testing.T) {
+	// A Wednesday evening.
+	at := time.Date(2024, time.May, 15, 21, 30, 0, 0, time.UTC)
+
+	if got := periodStart(at, trendPeriodDay); !got.Equal(time.Date(2024, time.May, 15, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected day start: %v", got)
+	}
+	if got := periodStart(at, trendPeriodWeek); !got.Equal(time.Date(2024, time.May, 13, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected the week to start on Monday, got %v", got)
+	}
+	if got := periodStart(at, trendPeriodMonth); !got.Equal(time.Date(2024, time.May, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected month start: %v", got)
+	}
+}
+
+func TestBuildTrendRollingAverage(t *testing.T) {
+	var results []ReplayMacroResult
+	start := time.Date(2024, time.January, 1, 20, 0, 0, 0, time.UTC)
+	for week := 0; week < 6; week++ {
+		results = append(results, ReplayMacroResult{
+			Matched:              true,
+			StartTime:            start.AddDate(0, 0, 7*week),
+			SupplyBlockedSeconds: 10 * (week + 1),
+			WorkerIdleSeconds:    60,
+		})
+	}
+	results = append(results, ReplayMacroResult{Matched: true, SupplyBlockedSeconds: 500})
+
+	trend := buildTrend(results, trendPeriodWeek)
+
+	if len(trend.Points) != 6 {
+		t.Fatalf("expected 6 weekly points without the undated replay, got %d", len(trend.Points))
+	}
+	last := trend.Points[5]
+	if last.AvgSupplyBlockedSeconds != 60 {
+		t.Fatalf("expected 60s in the last week, got %.1f", last.AvgSupplyBlockedSeconds)
+	}
+	// Weeks 3 to 6 average 30, 40, 50 and 60 seconds.
+	if last.RollingSupplyBlockedSeconds != 45 || last.RollingWorkerIdleSeconds != 60 {
+		t.Fatalf("unexpected rolling averages: %+v", last)
+	}
+	if first := trend.Points[0]; first.RollingSupplyBlockedSeconds != 10 {
+		t.Fatalf("expected the first rolling average to cover one week, got %+v", first)
+	}
+}
+
+func TestBuildTrendMixesCachedAndParsedTimes(t *testing.T) {
+	// The cache returns start times in UTC, fresh parses in local time.
+	at := time.Date(2024, time.May, 15, 12, 0, 0, 0, time.Local)
+	results := []ReplayMacroResult{
+		{Matched: true, StartTime: at.UTC(), SupplyBlockedSeconds: 10},
+		{Matched: true, StartTime: at, SupplyBlockedSeconds: 30},
+		{Matched: true, StartTime: at.Add(time.Hour).UTC(), SupplyBlockedSeconds: 20},
+	}
+
+	for _, period := range trendPeriods {
+		trend := buildTrend(results, period)
+		if len(trend.Points) != 1 || trend.Points[0].Games != 3 || trend.Points[0].AvgSupplyBlockedSeconds != 20 {
+			t.Fatalf("%s: expected one point with all 3 games, got %+v", period, trend.Points)
+		}
+	}
+}
+
+func TestRecentTrendChange(t *testing.T) {
+	latest := time.Date(2024, time.March, 31, 20, 0, 0, 0, time.UTC)
+	results := []ReplayMacroResult{
+		{Matched: true, StartTime: latest, SupplyBlockedSeconds: 44, WorkerIdleSeconds: 60},
+		{Matched: true, StartTime: latest.AddDate(0, 0, -10), SupplyBlockedSeconds: 44, WorkerIdleSeconds: 60},
+		{Matched: true, StartTime: latest.AddDate(0, 0, -40), SupplyBlockedSeconds: 50, WorkerIdleSeconds: 50},
+		{Matched: true, StartTime: latest.AddDate(0, 0, -100), SupplyBlockedSeconds: 900},
+	}
+
+	change := recentTrendChange(results, 30)
+
+	if change == nil {
+		t.Fatalf("expected a trend change")
+	}
+	if math.Abs(change.SupplyBlockedChange+0.12) > 1e-9 || math.Abs(change.WorkerIdleChange-0.2) > 1e-9 {
+		t.Fatalf("expected supply block down 12%% and worker idle up 20%%, got %+v", change)
+	}
+	if recentTrendChange(results[:2], 30) != nil {
+		t.Fatalf("expected no change without earlier games")
+	}
+}
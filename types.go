@@ -1,5 +1,7 @@
 package main
 
+import "time"
+
 // PlayerIdentity represents the current player's display name and alias set.
 type PlayerIdentity struct {
 	DisplayName string
@@ -11,29 +13,183 @@ type ScanTarget struct {
 	DisplayLabel string
 	Names        []string
 	ManualName   string
+	Analysis     AnalysisOptions
+	Roots        []ReplayRoot
+	CachePath    string
+	Workers      int
+	Filter       ScanFilter
+}
+
+// ScanFilter limits which replays a scan analyzes. Zero fields don't filter.
+type ScanFilter struct {
+	// From and Until bound the replay start time; Until is exclusive.
+	From  time.Time
+	Until time.Time
+	// MeleeOnly keeps Melee and 1v1 games, leaving out UMS, team and FFA
+	// game types.
+	MeleeOnly   bool
+	MinDuration time.Duration
+	// MinPlayers and MaxPlayers bound the number of players, not counting
+	// observers.
+	MinPlayers int
+	MaxPlayers int
+	// ExcludeComputers leaves out games with a computer player.
+	ExcludeComputers bool
+	// ExcludeObservers leaves out games the target only observed.
+	ExcludeObservers bool
+}
+
+// ReplayRoot is a folder scanned for replays besides the AutoSave folder.
+// Include and Exclude hold glob patterns matched against paths relative to
+// Path; an empty Include scans every replay.
+type ReplayRoot struct {
+	Path    string
+	Include []string
+	Exclude []string
+}
+
+// AnalysisOptions holds settings that change how a replay is simulated.
+type AnalysisOptions struct {
+	FilterIneffective bool
 }
 
 // ReplayMacroResult holds estimated macro metrics for one replay.
 type ReplayMacroResult struct {
-	Matched              bool
-	SupplyBlockedSeconds int
-	WorkerIdleSeconds    int
-	SupplyChart          []int
-	WorkerChart          []int
+	Matched               bool
+	SupplyBlockedSeconds  int
+	MaxedSeconds          int
+	WorkerIdleSeconds     int
+	ProductionIdleSeconds int
+	AvgUnspentResources   int
+	APM                   int
+	EAPM                  int
+	IneffectiveRatio      float64
+	SupplyChart           []int
+	WorkerChart           []int
+	ProductionChart       []int
+	ResourceChart         []int
+	ResourceChartBuckets  int
+	BuildOrder            []BuildOrderEntry
+	Opening               string
+	PlayerRace            string
+	OpponentRace          string
+	Matchup               string
+	MapName               string
+	Outcome               string
+	StartTime             time.Time
+	Opponents             []string
+}
+
+// BuildOrderEntry is one production order from the opening of a replay.
+type BuildOrderEntry struct {
+	Second int
+	Supply int
+	Kind   string
+	Action string
 }
 
 // MacroSummary holds the aggregated results shown in the UI.
 type MacroSummary struct {
-	TargetLabel               string
-	ScannedReplays            int
-	MatchedReplays            int
-	SkippedReplays            int
-	TotalSupplyBlockedSeconds int
-	TotalWorkerIdleSeconds    int
-	AvgSupplyBlockedSeconds   float64
-	AvgWorkerIdleSeconds      float64
-	SupplyRating              string
-	WorkerRating              string
-	SupplyChart               []int
-	WorkerChart               []int
+	TargetLabel                string
+	ScannedReplays             int
+	MatchedReplays             int
+	SkippedReplays             int
+	CachedReplays              int
+	FilteredReplays            int
+	Incomplete                 bool
+	TotalSupplyBlockedSeconds  int
+	TotalMaxedSeconds          int
+	TotalWorkerIdleSeconds     int
+	TotalProductionIdleSeconds int
+	AvgSupplyBlockedSeconds    float64
+	AvgMaxedSeconds            float64
+	AvgWorkerIdleSeconds       float64
+	AvgProductionIdleSeconds   float64
+	AvgUnspentResources        float64
+	AvgAPM                     float64
+	AvgEAPM                    float64
+	AvgIneffectiveRatio        float64
+	SupplyRating               string
+	WorkerRating               string
+	ProductionRating           string
+	ResourceRating             string
+	SupplyChart                []int
+	WorkerChart                []int
+	ProductionChart            []int
+	ResourceChart              []int
+	Openings                   []OpeningSummary
+	Matchups                   []MacroBreakdown
+	Outcomes                   []MacroBreakdown
+	Maps                       []MapSummary
+	Opponents                  []OpponentSummary
+	Trends                     []TrendSeries
+	RecentChange               *TrendChange
+}
+
+// TrendSeries holds supply block and worker idle over time, one point per
+// day, week or month with games.
+type TrendSeries struct {
+	Period string
+	Points []TrendPoint
+}
+
+// TrendPoint holds the averages of one period and the rolling averages up
+// to it.
+type TrendPoint struct {
+	Start                       time.Time
+	Games                       int
+	AvgSupplyBlockedSeconds     float64
+	AvgWorkerIdleSeconds        float64
+	RollingSupplyBlockedSeconds float64
+	RollingWorkerIdleSeconds    float64
+}
+
+// TrendChange compares the most recent days of replays with the days before.
+type TrendChange struct {
+	Days                int
+	SupplyBlockedChange float64
+	WorkerIdleChange    float64
+}
+
+// MapSummary holds the results of the replays played on one map.
+type MapSummary struct {
+	Name                    string
+	Games                   int
+	Wins                    int
+	Losses                  int
+	WinRate                 float64
+	AvgSupplyBlockedSeconds float64
+	AvgWorkerIdleSeconds    float64
+}
+
+// OpponentSummary holds the head-to-head history against one opponent.
+type OpponentSummary struct {
+	Name                     string
+	Games                    int
+	Wins                     int
+	Losses                   int
+	WinRate                  float64
+	Matchups                 []string
+	AvgSupplyBlockedSeconds  float64
+	AvgWorkerIdleSeconds     float64
+	AvgProductionIdleSeconds float64
+	LastPlayed               time.Time
+}
+
+// MacroBreakdown holds the aggregated results of one group of matched
+// replays, such as one matchup.
+type MacroBreakdown struct {
+	Label   string
+	Summary *MacroSummary
+}
+
+// OpeningSummary holds the average macro metrics of the replays that used
+// one opening.
+type OpeningSummary struct {
+	Name                     string
+	Games                    int
+	AvgSupplyBlockedSeconds  float64
+	AvgWorkerIdleSeconds     float64
+	AvgProductionIdleSeconds float64
+	AvgUnspentResources      float64
 }
@@ -1,4 +1,4 @@
-//go:build windows
+//go:build windows || darwin || gui
 
 package main
 
@@ -85,22 +85,37 @@ func (f FuturisticTheme) Size(name fyne.ThemeSizeName) float32 {
 }
 
 type AppUI struct {
-	Content      fyne.CanvasObject
-	ManualEntry  *widget.Entry
-	SummaryLabel *widget.Label
-	Progress     *widget.ProgressBar
-	StatusLabel  *widget.Label
-	ScanButton   *widget.Button
-	SupplyChart  *MiniBarChart
-	WorkerChart  *MiniBarChart
+	Content         fyne.CanvasObject
+	ManualEntry     *widget.Entry
+	FilterCheck     *widget.Check
+	RootsEntry      *widget.Entry
+	FromEntry       *widget.Entry
+	UntilEntry      *widget.Entry
+	MinLengthEntry  *widget.Entry
+	MeleeCheck      *widget.Check
+	OneVsOneCheck   *widget.Check
+	ComputersCheck  *widget.Check
+	ObserversCheck  *widget.Check
+	SummaryLabel    *widget.Label
+	Progress        *widget.ProgressBar
+	StatusLabel     *widget.Label
+	ScanButton      *widget.Button
+	CancelButton    *widget.Button
+	SupplyChart     *MiniBarChart
+	WorkerChart     *MiniBarChart
+	ProductionChart *MiniBarChart
+	ResourceChart   *MiniBarChart
+	ChartView       *widget.Select
+	TrendChart      *MiniBarChart
 }
 
 type MiniBarChart struct {
-	title  *widget.Label
-	footer *widget.Label
-	bars   *fyne.Container
-	root   *fyne.Container
-	color  color.Color
+	title        *widget.Label
+	footer       *widget.Label
+	bars         *fyne.Container
+	root         *fyne.Container
+	color        color.Color
+	formatFooter func([]int) string
 }
 
 // CreateUI builds the macro-analysis UI.
@@ -114,6 +129,24 @@ func CreateUI(identity PlayerIdentity) *AppUI {
 	manualEntry := widget.NewEntry()
 	manualEntry.SetPlaceHolder("Manual player name override (optional)")
 
+	filterCheck := widget.NewCheck("Ignore spam and ineffective commands", nil)
+	filterCheck.SetChecked(true)
+
+	rootsEntry := widget.NewMultiLineEntry()
+	rootsEntry.SetPlaceHolder("Extra replay folders, one per line (optional): folder | *.rep | !old")
+	rootsEntry.SetMinRowsVisible(2)
+
+	fromEntry := widget.NewEntry()
+	fromEntry.SetPlaceHolder("From (YYYY-MM-DD)")
+	untilEntry := widget.NewEntry()
+	untilEntry.SetPlaceHolder("Until (YYYY-MM-DD)")
+	minLengthEntry := widget.NewEntry()
+	minLengthEntry.SetPlaceHolder("Min. minutes")
+	meleeCheck := widget.NewCheck("Melee only", nil)
+	oneVsOneCheck := widget.NewCheck("1v1 only", nil)
+	computersCheck := widget.NewCheck("No computers", nil)
+	observersCheck := widget.NewCheck("Skip observed games", nil)
+
 	summaryLabel := widget.NewLabel(strings.Join(formatSummaryLines(nil), "\n"))
 	summaryLabel.Wrapping = fyne.TextWrapWord
 
@@ -124,38 +157,70 @@ func CreateUI(identity PlayerIdentity) *AppUI {
 	statusLabel.Alignment = fyne.TextAlignCenter
 
 	scanButton := widget.NewButton("Scan Macro Stats", nil)
+	cancelButton := widget.NewButton("Cancel", nil)
+	cancelButton.Disable()
 
-	supplyChart := NewMiniBarChart("Supply Block Chart (0:00-15:00)", color.RGBA{0, 255, 200, 255})
-	workerChart := NewMiniBarChart("Worker Idle Chart (0:00-15:00)", color.RGBA{255, 190, 64, 255})
+	supplyChart := NewMiniBarChart("Supply Block Chart (0:00-15:00)", color.RGBA{0, 255, 200, 255}, formatChartFooter)
+	workerChart := NewMiniBarChart("Worker Idle Chart (0:00-15:00)", color.RGBA{255, 190, 64, 255}, formatChartFooter)
+	productionChart := NewMiniBarChart("Production Idle Chart (0:00-15:00)", color.RGBA{255, 96, 160, 255}, formatChartFooter)
+	trendChart := NewMiniBarChart("Supply Block Trend (weekly, rolling average)", color.RGBA{0, 200, 255, 255}, formatTrendChartFooter)
+	trendChart.SetSeries(nil)
+
+	chartView := widget.NewSelect(nil, nil)
+	chartView.PlaceHolder = "Charts for..."
+
+	resourceChart := NewMiniBarChart("Unspent Resources Chart (0:00-15:00)", color.RGBA{120, 160, 255, 255}, formatResourceChartFooter)
 
 	content := container.NewVBox(
 		welcomeLabel,
 		autoTarget,
 		manualEntry,
+		filterCheck,
+		rootsEntry,
+		container.NewGridWithColumns(3, fromEntry, untilEntry, minLengthEntry),
+		container.NewGridWithColumns(4, meleeCheck, oneVsOneCheck, computersCheck, observersCheck),
 		widget.NewSeparator(),
-		scanButton,
+		container.NewGridWithColumns(2, scanButton, cancelButton),
 		progress,
 		statusLabel,
 		widget.NewSeparator(),
 		summaryLabel,
 		widget.NewSeparator(),
+		chartView,
 		supplyChart.CanvasObject(),
 		workerChart.CanvasObject(),
+		productionChart.CanvasObject(),
+		resourceChart.CanvasObject(),
+		trendChart.CanvasObject(),
 	)
 
 	return &AppUI{
-		Content:      container.NewVScroll(content),
-		ManualEntry:  manualEntry,
-		SummaryLabel: summaryLabel,
-		Progress:     progress,
-		StatusLabel:  statusLabel,
-		ScanButton:   scanButton,
-		SupplyChart:  supplyChart,
-		WorkerChart:  workerChart,
+		Content:         container.NewVScroll(content),
+		ManualEntry:     manualEntry,
+		FilterCheck:     filterCheck,
+		RootsEntry:      rootsEntry,
+		FromEntry:       fromEntry,
+		UntilEntry:      untilEntry,
+		MinLengthEntry:  minLengthEntry,
+		MeleeCheck:      meleeCheck,
+		OneVsOneCheck:   oneVsOneCheck,
+		ComputersCheck:  computersCheck,
+		ObserversCheck:  observersCheck,
+		SummaryLabel:    summaryLabel,
+		Progress:        progress,
+		StatusLabel:     statusLabel,
+		ScanButton:      scanButton,
+		CancelButton:    cancelButton,
+		SupplyChart:     supplyChart,
+		WorkerChart:     workerChart,
+		ProductionChart: productionChart,
+		ResourceChart:   resourceChart,
+		ChartView:       chartView,
+		TrendChart:      trendChart,
 	}
 }
 
-func NewMiniBarChart(title string, barColor color.Color) *MiniBarChart {
+func NewMiniBarChart(title string, barColor color.Color, formatFooter func([]int) string) *MiniBarChart {
 	titleLabel := widget.NewLabel(title)
 	titleLabel.TextStyle = fyne.TextStyle{Bold: true}
 
@@ -163,10 +228,11 @@ func NewMiniBarChart(title string, barColor color.Color) *MiniBarChart {
 	bars := container.NewGridWithColumns(chartBucketCount)
 
 	chart := &MiniBarChart{
-		title:  titleLabel,
-		footer: footerLabel,
-		bars:   bars,
-		color:  barColor,
+		title:        titleLabel,
+		footer:       footerLabel,
+		bars:         bars,
+		color:        barColor,
+		formatFooter: formatFooter,
 	}
 	chart.root = container.NewVBox(titleLabel, bars, footerLabel)
 	chart.SetSeries(make([]int, chartBucketCount))
@@ -197,7 +263,7 @@ func (c *MiniBarChart) SetSeries(series []int) {
 		c.bars.Add(container.NewVBox(layout.NewSpacer(), rect))
 	}
 
-	c.footer.SetText(formatChartFooter(series))
+	c.footer.SetText(c.formatFooter(series))
 	c.bars.Refresh()
 }
 
@@ -205,6 +271,35 @@ func UpdateSummaryUI(label *widget.Label, summary *MacroSummary) {
 	label.SetText(strings.Join(formatSummaryLines(summary), "\n"))
 }
 
+// UpdateChartsUI plots the summary's chart series, or empty charts for nil.
+func UpdateChartsUI(ui *AppUI, summary *MacroSummary) {
+	if summary == nil {
+		summary = &MacroSummary{}
+	}
+	ui.SupplyChart.SetSeries(chartSeriesOrEmpty(summary.SupplyChart))
+	ui.WorkerChart.SetSeries(chartSeriesOrEmpty(summary.WorkerChart))
+	ui.ProductionChart.SetSeries(chartSeriesOrEmpty(summary.ProductionChart))
+	ui.ResourceChart.SetSeries(chartSeriesOrEmpty(summary.ResourceChart))
+}
+
+// UpdateChartViewUI offers the summary's chart views, such as one per
+// matchup, and shows all games first.
+func UpdateChartViewUI(ui *AppUI, summary *MacroSummary) {
+	ui.ChartView.OnChanged = nil
+	ui.ChartView.ClearSelected()
+	ui.ChartView.Options = chartViews(summary)
+	ui.ChartView.Refresh()
+	ui.TrendChart.SetSeries(weeklyTrendSeries(summary))
+	if summary == nil {
+		UpdateChartsUI(ui, nil)
+		return
+	}
+	ui.ChartView.OnChanged = func(view string) {
+		UpdateChartsUI(ui, chartViewSummary(summary, view))
+	}
+	ui.ChartView.SetSelected(chartViewAll)
+}
+
 func formatIdentityLabel(identity PlayerIdentity) string {
 	if len(identity.Aliases) == 0 {
 		return identity.DisplayName
@@ -3,6 +3,9 @@ package main
 import (
 	"fmt"
 	"math"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func formatSummaryLines(summary *MacroSummary) []string {
@@ -14,9 +17,18 @@ func formatSummaryLines(summary *MacroSummary) []string {
 		fmt.Sprintf("Target: %s", summary.TargetLabel),
 		fmt.Sprintf("Matched Replays: %d", summary.MatchedReplays),
 	}
+	if summary.Incomplete {
+		lines = append(lines, fmt.Sprintf("Incomplete: scan canceled after %d replays", summary.ScannedReplays))
+	}
 	if summary.SkippedReplays > 0 {
 		lines = append(lines, fmt.Sprintf("Skipped Replays: %d", summary.SkippedReplays))
 	}
+	if summary.FilteredReplays > 0 {
+		lines = append(lines, fmt.Sprintf("Filtered Out: %d", summary.FilteredReplays))
+	}
+	if summary.CachedReplays > 0 {
+		lines = append(lines, fmt.Sprintf("Cached Replays: %d of %d", summary.CachedReplays, summary.ScannedReplays))
+	}
 
 	lines = append(lines,
 		fmt.Sprintf(
@@ -25,17 +37,253 @@ func formatSummaryLines(summary *MacroSummary) []string {
 			formatDurationSeconds(int(math.Round(summary.AvgSupplyBlockedSeconds))),
 			summary.SupplyRating,
 		),
+	)
+	if summary.TotalMaxedSeconds > 0 {
+		lines = append(lines, fmt.Sprintf(
+			"Maxed Out: %s total, %s avg (not counted as supply block)",
+			formatDurationSeconds(summary.TotalMaxedSeconds),
+			formatDurationSeconds(int(math.Round(summary.AvgMaxedSeconds))),
+		))
+	}
+	lines = append(lines,
 		fmt.Sprintf(
 			"Worker Idle: %s total, %s avg, rating: %s",
 			formatDurationSeconds(summary.TotalWorkerIdleSeconds),
 			formatDurationSeconds(int(math.Round(summary.AvgWorkerIdleSeconds))),
 			summary.WorkerRating,
 		),
+		fmt.Sprintf(
+			"Production Idle: %s total, %s avg, rating: %s",
+			formatDurationSeconds(summary.TotalProductionIdleSeconds),
+			formatDurationSeconds(int(math.Round(summary.AvgProductionIdleSeconds))),
+			summary.ProductionRating,
+		),
+		fmt.Sprintf(
+			"Unspent Resources: %d avg, rating: %s",
+			int(math.Round(summary.AvgUnspentResources)),
+			summary.ResourceRating,
+		),
 	)
+	if summary.AvgAPM > 0 {
+		lines = append(lines, fmt.Sprintf(
+			"Mechanics: %d APM, %d EAPM, %d%% ineffective",
+			int(math.Round(summary.AvgAPM)),
+			int(math.Round(summary.AvgEAPM)),
+			int(math.Round(summary.AvgIneffectiveRatio*100)),
+		))
+	}
+	if change := summary.RecentChange; change != nil {
+		lines = append(lines, fmt.Sprintf(
+			"Trend: supply block %s, worker idle %s over the last %d days",
+			formatChange(change.SupplyBlockedChange),
+			formatChange(change.WorkerIdleChange),
+			change.Days,
+		))
+	}
+	if len(summary.Outcomes) > 0 {
+		lines = append(lines, "By Result:")
+		for _, outcome := range summary.Outcomes {
+			lines = append(lines, formatBreakdownLine(outcome))
+		}
+	}
+	if len(summary.Matchups) > 0 {
+		lines = append(lines, "Matchups:")
+		for _, matchup := range summary.Matchups {
+			lines = append(lines, formatBreakdownLine(matchup))
+		}
+	}
+	if len(summary.Maps) > 0 {
+		lines = append(lines, "Maps:")
+		for _, mapSummary := range summary.Maps {
+			lines = append(lines, fmt.Sprintf(
+				"  %s: %d games, %s, %s supply block avg, %s worker idle avg",
+				mapSummary.Name,
+				mapSummary.Games,
+				formatWinRate(mapSummary.Wins, mapSummary.Losses, mapSummary.WinRate),
+				formatDurationSeconds(int(math.Round(mapSummary.AvgSupplyBlockedSeconds))),
+				formatDurationSeconds(int(math.Round(mapSummary.AvgWorkerIdleSeconds))),
+			))
+		}
+	}
+	if len(summary.Opponents) > 0 {
+		lines = append(lines, "Opponents:")
+		for _, opponent := range summary.Opponents {
+			lines = append(lines, fmt.Sprintf(
+				"  %s: %d games, %s, %s, %s supply block avg, %s worker idle avg, %s production idle avg, last played %s",
+				opponent.Name,
+				opponent.Games,
+				formatWinRate(opponent.Wins, opponent.Losses, opponent.WinRate),
+				strings.Join(opponent.Matchups, "/"),
+				formatDurationSeconds(int(math.Round(opponent.AvgSupplyBlockedSeconds))),
+				formatDurationSeconds(int(math.Round(opponent.AvgWorkerIdleSeconds))),
+				formatDurationSeconds(int(math.Round(opponent.AvgProductionIdleSeconds))),
+				formatLastPlayed(opponent.LastPlayed),
+			))
+		}
+	}
+	if len(summary.Openings) > 0 {
+		lines = append(lines, "Openings:")
+		for _, opening := range summary.Openings {
+			lines = append(lines, fmt.Sprintf(
+				"  %s: %d games, %s supply block avg, %s worker idle avg",
+				opening.Name,
+				opening.Games,
+				formatDurationSeconds(int(math.Round(opening.AvgSupplyBlockedSeconds))),
+				formatDurationSeconds(int(math.Round(opening.AvgWorkerIdleSeconds))),
+			))
+		}
+	}
 
 	return lines
 }
 
+// chartViewAll is the chart view covering every matched replay.
+const chartViewAll = "All games"
+
+// chartViews lists the groups of replays the charts can be shown for: all
+// games, wins and losses, then each matchup.
+func chartViews(summary *MacroSummary) []string {
+	if summary == nil {
+		return nil
+	}
+	views := []string{chartViewAll}
+	for _, breakdown := range chartBreakdowns(summary) {
+		views = append(views, breakdown.Label)
+	}
+	return views
+}
+
+// chartViewSummary returns the summary whose charts a view shows.
+func chartViewSummary(summary *MacroSummary, view string) *MacroSummary {
+	for _, breakdown := range chartBreakdowns(summary) {
+		if breakdown.Label == view {
+			return breakdown.Summary
+		}
+	}
+	return summary
+}
+
+func chartBreakdowns(summary *MacroSummary) []MacroBreakdown {
+	breakdowns := append([]MacroBreakdown(nil), summary.Outcomes...)
+	return append(breakdowns, summary.Matchups...)
+}
+
+// chartSeriesOrEmpty keeps the chart at its usual width before a scan.
+func chartSeriesOrEmpty(series []int) []int {
+	if len(series) == 0 {
+		return make([]int, chartBucketCount)
+	}
+	return series
+}
+
+func formatBreakdownLine(breakdown MacroBreakdown) string {
+	summary := breakdown.Summary
+	return fmt.Sprintf(
+		"  %s: %d games, %s supply block avg (%s), %s worker idle avg (%s), %s production idle avg (%s)",
+		breakdown.Label,
+		summary.MatchedReplays,
+		formatDurationSeconds(int(math.Round(summary.AvgSupplyBlockedSeconds))),
+		summary.SupplyRating,
+		formatDurationSeconds(int(math.Round(summary.AvgWorkerIdleSeconds))),
+		summary.WorkerRating,
+		formatDurationSeconds(int(math.Round(summary.AvgProductionIdleSeconds))),
+		summary.ProductionRating,
+	)
+}
+
+// formatChange describes a relative change, such as "down 12%".
+func formatChange(change float64) string {
+	percent := int(math.Round(change * 100))
+	switch {
+	case percent > 0:
+		return fmt.Sprintf("up %d%%", percent)
+	case percent < 0:
+		return fmt.Sprintf("down %d%%", -percent)
+	default:
+		return "unchanged"
+	}
+}
+
+func formatWinRate(wins, losses int, winRate float64) string {
+	if wins+losses == 0 {
+		return "win rate unknown"
+	}
+	return fmt.Sprintf("%d-%d (%d%% wins)", wins, losses, int(math.Round(winRate*100)))
+}
+
+// filterDateLayout is how dates are typed into the filter fields.
+const filterDateLayout = "2006-01-02"
+
+// parseScanFilter builds the scan filter from the filter fields. Dates are
+// inclusive days in local time; empty fields don't filter. oneVsOne keeps
+// melee games with exactly two players.
+func parseScanFilter(from, until, minMinutes string, melee, oneVsOne, excludeComputers, excludeObservers bool) (ScanFilter, error) {
+	filter := ScanFilter{
+		MeleeOnly:        melee || oneVsOne,
+		ExcludeComputers: excludeComputers,
+		ExcludeObservers: excludeObservers,
+	}
+	if oneVsOne {
+		filter.MinPlayers, filter.MaxPlayers = 2, 2
+	}
+	if from = strings.TrimSpace(from); from != "" {
+		day, err := time.ParseInLocation(filterDateLayout, from, time.Local)
+		if err != nil {
+			return ScanFilter{}, fmt.Errorf("invalid from date %q, use YYYY-MM-DD", from)
+		}
+		filter.From = day
+	}
+	if until = strings.TrimSpace(until); until != "" {
+		day, err := time.ParseInLocation(filterDateLayout, until, time.Local)
+		if err != nil {
+			return ScanFilter{}, fmt.Errorf("invalid until date %q, use YYYY-MM-DD", until)
+		}
+		filter.Until = day.AddDate(0, 0, 1)
+	}
+	if minMinutes = strings.TrimSpace(minMinutes); minMinutes != "" {
+		minutes, err := strconv.ParseFloat(minMinutes, 64)
+		if err != nil || minutes < 0 {
+			return ScanFilter{}, fmt.Errorf("invalid minimum duration %q, use minutes", minMinutes)
+		}
+		filter.MinDuration = time.Duration(minutes * float64(time.Minute))
+	}
+	return filter, nil
+}
+
+// parseReplayRoots reads the extra replay folders, one per line. Patterns
+// follow the folder after "|"; a leading "!" makes one an exclude:
+//
+//	D:\Replays\Pro | *.rep | !old
+func parseReplayRoots(text string) []ReplayRoot {
+	var roots []ReplayRoot
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.Split(line, "|")
+		root := ReplayRoot{Path: strings.TrimSpace(fields[0])}
+		if root.Path == "" {
+			continue
+		}
+		for _, field := range fields[1:] {
+			pattern := strings.TrimSpace(field)
+			switch {
+			case pattern == "" || pattern == "!":
+			case strings.HasPrefix(pattern, "!"):
+				root.Exclude = append(root.Exclude, pattern[1:])
+			default:
+				root.Include = append(root.Include, pattern)
+			}
+		}
+		roots = append(roots, root)
+	}
+	return roots
+}
+
+func formatLastPlayed(lastPlayed time.Time) string {
+	if lastPlayed.IsZero() {
+		return "unknown"
+	}
+	return lastPlayed.Format("2006-01-02")
+}
+
 func formatDurationSeconds(totalSeconds int) string {
 	minutes := totalSeconds / 60
 	seconds := totalSeconds % 60
@@ -54,3 +302,39 @@ func formatChartFooter(series []int) string {
 	}
 	return fmt.Sprintf("Peak bucket: %ds", peak)
 }
+
+// weeklyTrendSeries returns the rolling weekly supply block average in
+// seconds, oldest week first.
+func weeklyTrendSeries(summary *MacroSummary) []int {
+	if summary == nil {
+		return nil
+	}
+	for _, trend := range summary.Trends {
+		if trend.Period != trendPeriodWeek {
+			continue
+		}
+		series := make([]int, 0, len(trend.Points))
+		for _, point := range trend.Points {
+			series = append(series, int(math.Round(point.RollingSupplyBlockedSeconds)))
+		}
+		return series
+	}
+	return nil
+}
+
+func formatTrendChartFooter(series []int) string {
+	if len(series) == 0 {
+		return "No dated replays"
+	}
+	return fmt.Sprintf("Latest: %ds rolling avg, %d weeks played", series[len(series)-1], len(series))
+}
+
+func formatResourceChartFooter(series []int) string {
+	peak := 0
+	for _, value := range series {
+		if value > peak {
+			peak = value
+		}
+	}
+	return fmt.Sprintf("Peak bucket: %d unspent", peak)
+}
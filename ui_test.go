@@ -3,19 +3,61 @@ package main
 import (
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestFormatSummaryLines(t *testing.T) {
 	summary := &MacroSummary{
-		TargetLabel:               "Current Player (alpha, bravo)",
-		MatchedReplays:            4,
-		SkippedReplays:            1,
-		TotalSupplyBlockedSeconds: 80,
-		TotalWorkerIdleSeconds:    160,
-		AvgSupplyBlockedSeconds:   20,
-		AvgWorkerIdleSeconds:      40,
-		SupplyRating:              "Solid",
-		WorkerRating:              "Needs Work",
+		TargetLabel:                "Current Player (alpha, bravo)",
+		MatchedReplays:             4,
+		SkippedReplays:             1,
+		ScannedReplays:             12,
+		CachedReplays:              9,
+		TotalSupplyBlockedSeconds:  80,
+		TotalMaxedSeconds:          300,
+		AvgMaxedSeconds:            75,
+		TotalWorkerIdleSeconds:     160,
+		AvgSupplyBlockedSeconds:    20,
+		AvgWorkerIdleSeconds:       40,
+		TotalProductionIdleSeconds: 400,
+		AvgProductionIdleSeconds:   100,
+		ProductionRating:           "Solid",
+		AvgUnspentResources:        512.4,
+		AvgAPM:                     181.6,
+		AvgEAPM:                    140.2,
+		AvgIneffectiveRatio:        0.226,
+		SupplyRating:               "Solid",
+		WorkerRating:               "Needs Work",
+		ResourceRating:             "Solid",
+		Outcomes: []MacroBreakdown{
+			{Label: "Losses", Summary: &MacroSummary{MatchedReplays: 1, AvgSupplyBlockedSeconds: 90, SupplyRating: "Needs Work"}},
+		},
+		Matchups: []MacroBreakdown{
+			{Label: "TvZ", Summary: &MacroSummary{
+				MatchedReplays:           2,
+				AvgSupplyBlockedSeconds:  50,
+				SupplyRating:             "Needs Work",
+				AvgWorkerIdleSeconds:     30,
+				WorkerRating:             "Great",
+				AvgProductionIdleSeconds: 65,
+				ProductionRating:         "Great",
+			}},
+		},
+		RecentChange: &TrendChange{Days: 30, SupplyBlockedChange: -0.12, WorkerIdleChange: 0.05},
+		Maps: []MapSummary{
+			{Name: "Fighting Spirit", Games: 4, Wins: 2, Losses: 1, WinRate: 2.0 / 3, AvgSupplyBlockedSeconds: 20, AvgWorkerIdleSeconds: 90},
+			{Name: "Python", Games: 1, AvgSupplyBlockedSeconds: 5},
+		},
+		Opponents: []OpponentSummary{
+			{
+				Name: "bravo", Games: 3, Wins: 2, Losses: 1, WinRate: 2.0 / 3, Matchups: []string{"TvP", "TvZ"},
+				AvgSupplyBlockedSeconds: 20, AvgWorkerIdleSeconds: 40, AvgProductionIdleSeconds: 65,
+				LastPlayed: time.Date(2024, 5, 12, 20, 0, 0, 0, time.UTC),
+			},
+		},
+		Openings: []OpeningSummary{
+			{Name: "12 Hatch", Games: 3, AvgSupplyBlockedSeconds: 75, AvgWorkerIdleSeconds: 20},
+		},
 	}
 
 	lines := formatSummaryLines(summary)
@@ -30,16 +72,138 @@ func TestFormatSummaryLines(t *testing.T) {
 	if !strings.Contains(joined, "Skipped Replays: 1") {
 		t.Fatalf("missing skipped replay count: %q", joined)
 	}
+	if strings.Contains(joined, "Incomplete") {
+		t.Fatalf("expected no incomplete note for a finished scan: %q", joined)
+	}
+	if got := strings.Join(formatSummaryLines(&MacroSummary{ScannedReplays: 7, Incomplete: true}), "\n"); !strings.Contains(got, "Incomplete: scan canceled after 7 replays") {
+		t.Fatalf("missing incomplete note: %q", got)
+	}
+	if got := strings.Join(formatSummaryLines(&MacroSummary{FilteredReplays: 5}), "\n"); !strings.Contains(got, "Filtered Out: 5") {
+		t.Fatalf("missing filtered count: %q", got)
+	}
+	if !strings.Contains(joined, "Cached Replays: 9 of 12") {
+		t.Fatalf("missing cached replay count: %q", joined)
+	}
 	if !strings.Contains(joined, "Supply Block: 1m20s total, 20s avg, rating: Solid") {
 		t.Fatalf("missing supply summary: %q", joined)
 	}
+	if !strings.Contains(joined, "Maxed Out: 5m00s total, 1m15s avg") {
+		t.Fatalf("missing maxed summary: %q", joined)
+	}
 	if !strings.Contains(joined, "Worker Idle: 2m40s total, 40s avg, rating: Needs Work") {
 		t.Fatalf("missing worker summary: %q", joined)
 	}
+	if !strings.Contains(joined, "Production Idle: 6m40s total, 1m40s avg, rating: Solid") {
+		t.Fatalf("missing production summary: %q", joined)
+	}
+	if !strings.Contains(joined, "Unspent Resources: 512 avg, rating: Solid") {
+		t.Fatalf("missing unspent resources summary: %q", joined)
+	}
+	if !strings.Contains(joined, "Mechanics: 182 APM, 140 EAPM, 23% ineffective") {
+		t.Fatalf("missing mechanics summary: %q", joined)
+	}
+	if !strings.Contains(joined, "By Result:\n  Losses: 1 games, 1m30s supply block avg (Needs Work)") {
+		t.Fatalf("missing result breakdown: %q", joined)
+	}
+	if !strings.Contains(joined, "TvZ: 2 games, 50s supply block avg (Needs Work), 30s worker idle avg (Great), 1m05s production idle avg (Great)") {
+		t.Fatalf("missing matchup breakdown: %q", joined)
+	}
+	if !strings.Contains(joined, "Trend: supply block down 12%, worker idle up 5% over the last 30 days") {
+		t.Fatalf("missing trend summary: %q", joined)
+	}
+	if !strings.Contains(joined, "Fighting Spirit: 4 games, 2-1 (67% wins), 20s supply block avg, 1m30s worker idle avg") {
+		t.Fatalf("missing map breakdown: %q", joined)
+	}
+	if !strings.Contains(joined, "Python: 1 games, win rate unknown") {
+		t.Fatalf("missing map without results: %q", joined)
+	}
+	if !strings.Contains(joined, "bravo: 3 games, 2-1 (67% wins), TvP/TvZ, 20s supply block avg, 40s worker idle avg, 1m05s production idle avg, last played 2024-05-12") {
+		t.Fatalf("missing opponent history: %q", joined)
+	}
+	if !strings.Contains(joined, "12 Hatch: 3 games, 1m15s supply block avg, 20s worker idle avg") {
+		t.Fatalf("missing opening breakdown: %q", joined)
+	}
+}
+
+func TestParseReplayRoots(t *testing.T) {
+	roots := parseReplayRoots("D:\\Replays\\Pro | *.rep | !old\n\n  C:\\Saved  \n")
+
+	if len(roots) != 2 {
+		t.Fatalf("expected 2 roots, got %+v", roots)
+	}
+	pro := roots[0]
+	if pro.Path != "D:\\Replays\\Pro" || len(pro.Include) != 1 || pro.Include[0] != "*.rep" || len(pro.Exclude) != 1 || pro.Exclude[0] != "old" {
+		t.Fatalf("unexpected first root: %+v", pro)
+	}
+	if roots[1].Path != "C:\\Saved" || roots[1].Include != nil || roots[1].Exclude != nil {
+		t.Fatalf("unexpected second root: %+v", roots[1])
+	}
+}
+
+func TestParseScanFilter(t *testing.T) {
+	filter, err := parseScanFilter("2024-05-01", " 2024-05-31 ", "2.5", false, true, true, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !filter.From.Equal(time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)) || !filter.Until.Equal(time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local)) {
+		t.Fatalf("expected May 2024 inclusive, got %v to %v", filter.From, filter.Until)
+	}
+	if !filter.MeleeOnly || filter.MinPlayers != 2 || filter.MaxPlayers != 2 || !filter.ExcludeComputers || filter.ExcludeObservers {
+		t.Fatalf("unexpected 1v1 filter: %+v", filter)
+	}
+	if filter.MinDuration != 150*time.Second {
+		t.Fatalf("expected 2m30s minimum, got %v", filter.MinDuration)
+	}
+
+	if empty, err := parseScanFilter("", "", "", false, false, false, false); err != nil || empty != (ScanFilter{}) {
+		t.Fatalf("expected empty fields not to filter, got %+v (%v)", empty, err)
+	}
+	if _, err := parseScanFilter("05/01/2024", "", "", false, false, false, false); err == nil {
+		t.Fatalf("expected an invalid date to fail")
+	}
+	if _, err := parseScanFilter("", "", "ten", false, false, false, false); err == nil {
+		t.Fatalf("expected an invalid duration to fail")
+	}
 }
 
 func TestFormatChartFooter(t *testing.T) {
 	if got := formatChartFooter([]int{0, 4, 9}); got != "Peak bucket: 9s" {
 		t.Fatalf("unexpected chart footer: %q", got)
 	}
+	if got := formatResourceChartFooter([]int{120, 640, 300}); got != "Peak bucket: 640 unspent" {
+		t.Fatalf("unexpected resource chart footer: %q", got)
+	}
+}
+
+func TestChartViews(t *testing.T) {
+	tvz := &MacroSummary{TargetLabel: "TvZ"}
+	wins := &MacroSummary{TargetLabel: "Wins"}
+	summary := &MacroSummary{
+		Outcomes: []MacroBreakdown{{Label: "Wins", Summary: wins}},
+		Matchups: []MacroBreakdown{{Label: "TvZ", Summary: tvz}},
+	}
+
+	views := chartViews(summary)
+	if len(views) != 3 || views[0] != chartViewAll || views[1] != "Wins" || views[2] != "TvZ" {
+		t.Fatalf("unexpected chart views: %v", views)
+	}
+	if chartViewSummary(summary, "TvZ") != tvz || chartViewSummary(summary, "Wins") != wins ||
+		chartViewSummary(summary, chartViewAll) != summary {
+		t.Fatalf("expected each view to show its own summary")
+	}
+}
+
+func TestWeeklyTrendSeries(t *testing.T) {
+	summary := &MacroSummary{Trends: []TrendSeries{
+		{Period: trendPeriodDay, Points: []TrendPoint{{RollingSupplyBlockedSeconds: 99}}},
+		{Period: trendPeriodWeek, Points: []TrendPoint{{RollingSupplyBlockedSeconds: 12.4}, {RollingSupplyBlockedSeconds: 20.6}}},
+	}}
+
+	series := weeklyTrendSeries(summary)
+	if len(series) != 2 || series[0] != 12 || series[1] != 21 {
+		t.Fatalf("unexpected weekly trend series: %v", series)
+	}
+	if got := formatTrendChartFooter(series); got != "Latest: 21s rolling avg, 2 weeks played" {
+		t.Fatalf("unexpected trend chart footer: %q", got)
+	}
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// scanWorkers is the number of replays parsed at once, defaulting to one per
+// CPU.
+func (t ScanTarget) scanWorkers() int {
+	if t.Workers > 0 {
+		return t.Workers
+	}
+	return runtime.NumCPU()
+}
+
+// scanReplayFiles runs scan over every replay on a pool of workers.
+// analyses[i] belongs to repFiles[i] whichever worker finishes first, so the
+// aggregation downstream doesn't depend on the pool size. Progress is
+// reported once per finished replay and never goes backwards.
+//
+// Once ctx is done no new replay is started. Replays that weren't scanned,
+// or whose scan returned an error, keep a zero analysis, which aggregates as
+// an unmatched replay; scanned counts the others.
+func scanReplayFiles(ctx context.Context, repFiles []string, workers int, scan func(context.Context, string) (replayAnalysis, error), progressCallback func(float64)) (analyses []replayAnalysis, scanned int) {
+	analyses = make([]replayAnalysis, len(repFiles))
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(repFiles) {
+		workers = len(repFiles)
+	}
+
+	indexes := make(chan int)
+	var progressMu sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				analysis, err := scan(ctx, repFiles[index])
+				if err != nil {
+					continue
+				}
+				analyses[index] = analysis
+
+				progressMu.Lock()
+				scanned++
+				if progressCallback != nil {
+					progressCallback(float64(scanned) / float64(len(repFiles)))
+				}
+				progressMu.Unlock()
+			}
+		}()
+	}
+feed:
+	for index := range repFiles {
+		// select picks at random when a worker is also ready, so check for
+		// a cancel first.
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case indexes <- index:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	return analyses, scanned
+}
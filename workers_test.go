@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	screp "github.com/icza/screp/rep"
+	"github.com/icza/screp/rep/repcmd"
+	"github.com/icza/screp/rep/repcore"
+)
+
+func TestScanReplayFilesKeepsFileOrder(t *testing.T) {
+	repFiles := make([]string, 20)
+	for i := range repFiles {
+		repFiles[i] = fmt.Sprintf("game-%03d.rep", i)
+	}
+
+	var progress []float64
+	analyses, scanned := scanReplayFiles(context.Background(), repFiles, 4, func(_ context.Context, repFile string) (replayAnalysis, error) {
+		var index int
+		fmt.Sscanf(repFile, "game-%03d.rep", &index)
+		// Later files finish first.
+		time.Sleep(time.Duration(len(repFiles)-index) * time.Millisecond)
+		return replayAnalysis{Result: ReplayMacroResult{Matched: true, SupplyBlockedSeconds: index}}, nil
+	}, func(p float64) { progress = append(progress, p) })
+
+	if scanned != len(repFiles) {
+		t.Fatalf("expected every replay scanned, got %d", scanned)
+	}
+
+	for i, analysis := range analyses {
+		if analysis.Result.SupplyBlockedSeconds != i {
+			t.Fatalf("expected analysis %d in place, got %d", i, analysis.Result.SupplyBlockedSeconds)
+		}
+	}
+	if len(progress) != len(repFiles) || progress[len(progress)-1] != 1 {
+		t.Fatalf("expected one progress update per replay ending at 1, got %v", progress)
+	}
+	for i := 1; i < len(progress); i++ {
+		if progress[i] < progress[i-1] {
+			t.Fatalf("progress went backwards: %v", progress)
+		}
+	}
+}
+
+func TestParallelScanMatchesSerial(t *testing.T) {
+	repFiles := make([]string, 48)
+	for i := range repFiles {
+		repFiles[i] = fmt.Sprintf("game-%03d.rep", i)
+	}
+	target := ScanTarget{DisplayLabel: "alpha", Names: []string{"alpha"}}
+	scan := func(_ context.Context, repFile string) (replayAnalysis, error) {
+		var index int
+		fmt.Sscanf(repFile, "game-%03d.rep", &index)
+		return analyzeSyntheticReplay(index, target), nil
+	}
+
+	serialAnalyses, _ := scanReplayFiles(context.Background(), repFiles, 1, scan, nil)
+	serial := summarizeReplayAnalyses(target, serialAnalyses)
+	for _, workers := range []int{2, 8, 64} {
+		parallelAnalyses, _ := scanReplayFiles(context.Background(), repFiles, workers, scan, nil)
+		parallel := summarizeReplayAnalyses(target, parallelAnalyses)
+		if !reflect.DeepEqual(serial, parallel) {
+			t.Fatalf("summary with %d workers differs from the serial one:\nserial:   %+v\nparallel: %+v", workers, serial, parallel)
+		}
+	}
+	if serial.MatchedReplays == 0 || serial.SkippedReplays == 0 {
+		t.Fatalf("expected the corpus to mix matched and skipped replays, got %+v", serial)
+	}
+}
+
+func TestScanReplayFilesStopsOnCancel(t *testing.T) {
+	repFiles := make([]string, 100)
+	for i := range repFiles {
+		repFiles[i] = fmt.Sprintf("game-%03d.rep", i)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	analyses, scanned := scanReplayFiles(ctx, repFiles, 2, func(ctx context.Context, repFile string) (replayAnalysis, error) {
+		if repFile == "game-009.rep" {
+			cancel()
+		}
+		if err := ctx.Err(); err != nil {
+			return replayAnalysis{}, err
+		}
+		return replayAnalysis{Result: ReplayMacroResult{Matched: true}}, nil
+	}, nil)
+
+	if scanned == 0 || scanned >= 12 {
+		t.Fatalf("expected the scan to stop shortly after the cancel, scanned %d", scanned)
+	}
+	matched := 0
+	for _, analysis := range analyses {
+		if analysis.Result.Matched {
+			matched++
+		}
+	}
+	if matched != scanned {
+		t.Fatalf("expected only scanned replays to have results, got %d of %d", matched, scanned)
+	}
+}
+
+func TestScanReplayFilesCanceledDispatchesNothing(t *testing.T) {
+	repFiles := make([]string, 100)
+	for i := range repFiles {
+		repFiles[i] = fmt.Sprintf("game-%03d.rep", i)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var calls int32
+	_, scanned := scanReplayFiles(ctx, repFiles, 4, func(ctx context.Context, repFile string) (replayAnalysis, error) {
+		atomic.AddInt32(&calls, 1)
+		return replayAnalysis{}, nil
+	}, nil)
+
+	if calls != 0 || scanned != 0 {
+		t.Fatalf("expected no replays dispatched after a cancel, got %d calls and %d scanned", calls, scanned)
+	}
+}
+
+func TestScanMacroStatsCanceledIsIncomplete(t *testing.T) {
+	dir := t.TempDir()
+	createReplayFiles(t, dir, "a.rep", "b.rep")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	summary, err := scanMacroStats(ctx, ScanTarget{Names: []string{"alpha"}, Roots: []ReplayRoot{{Path: dir}}}, nil)
+	if err != nil {
+		t.Fatalf("expected partial results instead of an error, got %v", err)
+	}
+	if !summary.Incomplete || summary.ScannedReplays != 0 {
+		t.Fatalf("expected an incomplete summary with nothing scanned, got %+v", summary)
+	}
+}
+
+func TestFindReplayFilesInRootsCanceled(t *testing.T) {
+	dir := t.TempDir()
+	createReplayFiles(t, dir, "2026-01-01/a.rep")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := findReplayFilesInRoots(ctx, []ReplayRoot{{Path: dir}}, nil); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+// analyzeSyntheticReplay stands in for parsing replay index of a generated
+// corpus: every seventh replay is unreadable and every fifth has no alpha.
+func analyzeSyntheticReplay(index int, target ScanTarget) replayAnalysis {
+	if index%7 == 6 {
+		return replayAnalysis{Skipped: true}
+	}
+	rep := syntheticReplay(index)
+	player, opponents := findMatchingPlayer(rep, target.Names)
+	if player == nil {
+		return replayAnalysis{}
+	}
+	result := analyzeMatchedReplay(rep, player, target.Analysis)
+	result.Opponents = opponentNames(opponents)
+	return replayAnalysis{Result: result}
+}
+
+func syntheticReplay(index int) *screp.Replay {
+	cmds := []timedCmd{buildWorker(index % 13)}
+	for second := 20; second < 240; second += 15 + index%11 {
+		cmds = append(cmds, buildWorker(second))
+	}
+	cmds = append(cmds, buildBuilding(60+index%40, repcmd.UnitIDSupplyDepot))
+
+	rep := terranReplayWithCommands(cmds, 300+index*7)
+	if index%5 == 4 {
+		rep.Header.Players[0].Name = "delta"
+	}
+	races := []*repcore.Race{repcore.RaceZerg, repcore.RaceProtoss, repcore.RaceTerran}
+	addOpponent(rep, fmt.Sprintf("opponent%d", index%4), races[index%3])
+	rep.Header.Map = []string{"Fighting Spirit 1.3", "Polypoid v1.65", "(2)Eclipse"}[index%3]
+	rep.Header.StartTime = time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC).AddDate(0, 0, index*3)
+	rep.Computed = &screp.Computed{WinnerTeam: byte(1 + index%2)}
+	return rep
+}